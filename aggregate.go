@@ -0,0 +1,164 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/alyu/logger/handler"
+)
+
+// entryHandler is implemented by handlers that work on log entries rather than formatted lines.
+type entryHandler interface {
+	handleEntry(e *Entry)
+}
+
+// templateNumbers matches the variable parts replaced in a message template.
+var templateNumbers = regexp.MustCompile(`0x[0-9a-fA-F]+|[0-9]+(\.[0-9]+)?`)
+
+// messageTemplate normalizes msg by replacing numbers with <n>, so that
+// "took 12ms" and "took 7ms" share the template "took <n>ms".
+func messageTemplate(msg string) string {
+	return templateNumbers.ReplaceAllString(msg, "<n>")
+}
+
+type aggregateKey struct {
+	level    SeverityFilter
+	template string
+}
+
+// AggregatingHandler counts the log lines per severity and message template instead of
+// writing them to the handler it wraps. Each window the counts are written to the wrapped
+// handler as summary lines, e.g. "request took <n>ms count=42", formatted by the logger.
+// The other handlers of the logger get the lines as usual.
+type AggregatingHandler struct {
+	handler.Handler
+	l      *Logger4go
+	counts map[aggregateKey]int
+	order  []aggregateKey // templates in order of first occurrence
+	stop   chan struct{}
+	done   chan struct{}
+	mutex  sync.Mutex
+}
+
+// AddAggregatingHandler adds a handler that aggregates the log lines to h and writes a
+// summary to h each window, e.g. to keep a remote collector from being flooded.
+func (l *Logger4go) AddAggregatingHandler(h handler.Handler, window time.Duration) (ah *AggregatingHandler, err error) {
+	ah = &AggregatingHandler{Handler: h, l: l, counts: make(map[aggregateKey]int), stop: make(chan struct{}), done: make(chan struct{})}
+	if err = registerHandler(l, ah); err != nil {
+		return nil, err
+	}
+	go ah.run(window)
+	return ah, nil
+}
+
+// Write passes lines written to the logger output directly, e.g. with WriteRaw, on to the
+// wrapped handler. Logged lines are aggregated.
+func (ah *AggregatingHandler) Write(b []byte) (n int, err error) {
+	return ah.Handler.Write(b)
+}
+
+// Flush writes the summary lines to the wrapped handler, resets the counts and flushes
+// the wrapped handler.
+func (ah *AggregatingHandler) Flush() error {
+	ah.mutex.Lock()
+	counts, order := ah.counts, ah.order
+	ah.counts, ah.order = make(map[aggregateKey]int), nil
+	ah.mutex.Unlock()
+
+	var firstErr error
+	flags := ah.l.Flags()
+	for _, k := range order {
+		e := &Entry{Time: now(), Level: k.level, Name: ah.l.name, Prefix: ah.l.Prefix(), Flags: flags,
+			Message: k.template, Fields: Fields{"count": counts[k]}}
+		if flags&(log.Lshortfile|log.Llongfile) != 0 {
+			// a summary has no call site
+			e.File = "???"
+		}
+		b, err := ah.l.Formatter().Format(e)
+		if err == nil {
+			_, err = writeHandler(ah.Handler, k.level, b)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := handler.Flush(ah.Handler); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Verify verifies the wrapped handler.
+func (ah *AggregatingHandler) Verify() error {
+	return verifyHandler(ah.Handler)
+}
+
+// Close stops the handler, writes the remaining counts and closes the wrapped handler.
+func (ah *AggregatingHandler) Close() error {
+	ah.mutex.Lock()
+	select {
+	case <-ah.stop:
+		ah.mutex.Unlock()
+		return nil
+	default:
+		close(ah.stop)
+	}
+	ah.mutex.Unlock()
+
+	<-ah.done
+	err := ah.Flush()
+	if cerr := ah.Handler.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// String returns the handler name.
+func (ah *AggregatingHandler) String() string {
+	return "AggregatingHandler(" + ah.Handler.String() + ")"
+}
+
+// Describe returns the description of the wrapped handler.
+func (ah *AggregatingHandler) Describe() string {
+	return handler.Describe(ah.Handler) + " (aggregated)"
+}
+
+func (ah *AggregatingHandler) handleEntry(e *Entry) {
+	k := aggregateKey{e.Level, messageTemplate(e.Message)}
+
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+
+	if _, ok := ah.counts[k]; !ok {
+		ah.order = append(ah.order, k)
+	}
+	ah.counts[k]++
+}
+
+func (ah *AggregatingHandler) run(window time.Duration) {
+	defer close(ah.done)
+
+	t := time.NewTicker(window)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := ah.Flush(); err != nil {
+				ah.l.mutex.Lock()
+				onError := ah.l.onWriteError
+				ah.l.mutex.Unlock()
+				if onError != nil {
+					onError(ah, err)
+				}
+			}
+		case <-ah.stop:
+			return
+		}
+	}
+}
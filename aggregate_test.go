@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bufferHandler collects the written lines.
+type bufferHandler struct {
+	buf   bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (bh *bufferHandler) Write(b []byte) (int, error) {
+	bh.mutex.Lock()
+	defer bh.mutex.Unlock()
+	return bh.buf.Write(b)
+}
+
+func (bh *bufferHandler) Close() error { return nil }
+
+func (bh *bufferHandler) String() string {
+	bh.mutex.Lock()
+	defer bh.mutex.Unlock()
+	return bh.buf.String()
+}
+
+func TestAggregatingHandler(t *testing.T) {
+	lg := GetWithFlags("agg", 0)
+	out := &bufferHandler{}
+	ah, err := lg.AddAggregatingHandler(out, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lg.RemoveHandler(ah)
+	raw := &bufferHandler{}
+	lg.AddHandler(raw)
+	defer lg.RemoveHandler(raw)
+
+	for i := 0; i < 3; i++ {
+		lg.Errf("connection to 10.0.0.%d failed", i)
+	}
+	lg.Infof("request took %dms", 12)
+	lg.Infof("request took %dms", 7)
+	lg.Errf("disk %d full", 2)
+
+	if out.String() != "" {
+		t.Errorf("Expected no lines before the summary, got %q", out.String())
+	}
+	if n := strings.Count(raw.String(), "\n"); n != 6 {
+		t.Errorf("Expected the other handler to get the 6 lines, got %q", raw.String())
+	}
+	rawBefore := raw.String()
+	ah.Close()
+
+	want := "agg  err      connection to <n>.<n> failed count=3\n" +
+		"agg  info     request took <n>ms count=2\n" +
+		"agg  err      disk <n> full count=1\n"
+	if got := out.String(); got != want {
+		t.Errorf("Unexpected summary:\n got  %q\n want %q", got, want)
+	}
+	if raw.String() != rawBefore {
+		t.Errorf("Unexpected summary in the other handler: %q", raw.String()[len(rawBefore):])
+	}
+
+	// counts are reset after a summary
+	before := out.String()
+	ah.Flush()
+	if out.String() != before {
+		t.Errorf("Unexpected summary after reset: %q", out.String()[len(before):])
+	}
+}
+
+func TestAggregatingHandlerFiltered(t *testing.T) {
+	lg := GetWithFlags("agg_filtered", 0)
+	out := &bufferHandler{}
+	ah := &AggregatingHandler{Handler: out, l: lg, counts: make(map[aggregateKey]int)}
+	fh, err := lg.AddFilteredHandler(ah, ErrSeverity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lg.RemoveHandler(fh)
+
+	lg.Errf("disk %d full", 1)
+	lg.Errf("disk %d full", 2)
+	lg.Info("filtered")
+	if out.String() != "" {
+		t.Errorf("Expected the lines aggregated, got %q", out.String())
+	}
+
+	// the summary is written by the logger's Flush
+	if err := lg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "agg_filtered  err      disk <n> full count=2\n"; out.String() != want {
+		t.Errorf("Unexpected summary:\n got  %q\n want %q", out.String(), want)
+	}
+
+	// a summary has no caller without the caller flags
+	out.buf.Reset()
+	lg.SetFormatter(&JSONFormatter{})
+	lg.Errf("disk %d full", 3)
+	lg.Flush()
+	if !strings.Contains(out.String(), `"count":1`) || strings.Contains(out.String(), "caller") {
+		t.Errorf("Unexpected caller in %s", out.String())
+	}
+}
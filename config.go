@@ -0,0 +1,301 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/syslog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alyu/logger/handler"
+)
+
+// Config describes a set of named loggers and their handlers.
+//
+// Example:
+//
+//	{
+//	  "loggers": {
+//	    "audit": {
+//	      "filter": "info,err",
+//	      "handlers": [
+//	        {"type": "file", "path": "/var/log/audit.log", "maxSize": "10MB", "rotate": 7, "compress": true}
+//	      ]
+//	    }
+//	  }
+//	}
+type Config struct {
+	Loggers map[string]LoggerConfig `json:"loggers"`
+}
+
+// LoggerConfig describes a single logger.
+type LoggerConfig struct {
+	// Comma separated severity names, e.g. "info,err" or "all". All severities are enabled if empty.
+	Filter string `json:"filter"`
+	// log header flags, e.g. log.LstdFlags. Not changed if nil.
+	Flags    *int            `json:"flags"`
+	Handlers []HandlerConfig `json:"handlers"`
+}
+
+// HandlerConfig describes a handler. Type is one of stdout, stderr, file or syslog
+// and decides which of the other settings apply.
+type HandlerConfig struct {
+	Type string `json:"type"`
+
+	// file handler
+	Path     string `json:"path"`
+	MaxSize  string `json:"maxSize"` // bytes or with a unit, e.g. "10MB". Defaults to 1MB.
+	Rotate   *byte  `json:"rotate"`  // defaults to 5
+	Compress bool   `json:"compress"`
+	Daily    bool   `json:"daily"`
+	Interval string `json:"interval"` // rotate at each interval, e.g. "1h"
+
+	// syslog handler
+	Protocol string `json:"protocol"` // tcp|udp, local syslog daemon if empty
+	Addr     string `json:"addr"`
+	Facility string `json:"facility"` // e.g. local0. Defaults to user.
+	Severity string `json:"severity"` // e.g. info. Defaults to info.
+	Tag      string `json:"tag"`
+}
+
+// ConfigureFromFile reads a JSON config file, see Config, and sets up the described loggers.
+func ConfigureFromFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("Unable to parse config file %s: %v", path, err)
+	}
+	return Configure(&cfg)
+}
+
+// Configure sets up the loggers described by cfg. Loggers are fetched with Get so
+// handlers are added to an already existing logger with the same name.
+//
+// The filters and handlers of all loggers are created, and verified if the logger has
+// SetVerifyOnAdd set, before any logger is changed. If one of them fails, the handlers
+// created so far are closed and the loggers are left as they were.
+func Configure(cfg *Config) error {
+	names := make([]string, 0, len(cfg.Loggers))
+	for name := range cfg.Loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var loggers []*configuredLogger
+	for _, name := range names {
+		cl, err := newConfiguredLogger(name, cfg.Loggers[name])
+		if err != nil {
+			for _, cl := range loggers {
+				cl.close()
+			}
+			return err
+		}
+		loggers = append(loggers, cl)
+	}
+
+	for i, cl := range loggers {
+		if err := cl.apply(); err != nil {
+			for _, cl := range loggers[i+1:] {
+				cl.close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// configuredLogger is a logger described by a LoggerConfig, created but not applied yet.
+type configuredLogger struct {
+	name     string
+	filter   SeverityFilter
+	flags    *int
+	handlers []handler.Handler
+}
+
+func newConfiguredLogger(name string, lc LoggerConfig) (*configuredLogger, error) {
+	cl := &configuredLogger{name: name, filter: AllSeverity, flags: lc.Flags}
+	if lc.Filter != "" {
+		f, err := ParseSeverityList(lc.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter for logger %q: %v", name, err)
+		}
+		cl.filter = f
+	}
+
+	verify := false
+	mu.RLock()
+	l := loggers4go[name]
+	mu.RUnlock()
+	if l != nil {
+		l.mutex.Lock()
+		verify = l.verifyOnAdd
+		l.mutex.Unlock()
+	}
+
+	for _, hc := range lc.Handlers {
+		h, err := newConfiguredHandler(hc)
+		if err == nil && verify {
+			if err = verifyHandler(h); err != nil {
+				h.Close()
+				err = fmt.Errorf("Unable to write to %v: %v", h, err)
+			}
+		}
+		if err != nil {
+			cl.close()
+			return nil, fmt.Errorf("Unable to configure logger %q: %v", name, err)
+		}
+		cl.handlers = append(cl.handlers, h)
+	}
+	return cl, nil
+}
+
+// apply sets up the logger. If a handler can't be added, it and the handlers after it are closed.
+func (cl *configuredLogger) apply() error {
+	l := Get(cl.name)
+	if cl.flags != nil {
+		l.SetFlags(*cl.flags)
+	}
+	l.SetFilter(cl.filter)
+	for i, h := range cl.handlers {
+		if err := l.AddHandler(h); err != nil {
+			for _, h := range cl.handlers[i:] {
+				h.Close()
+			}
+			return fmt.Errorf("Unable to configure logger %q: %v", cl.name, err)
+		}
+	}
+	return nil
+}
+
+// close closes the handlers of a logger that is not applied.
+func (cl *configuredLogger) close() {
+	for _, h := range cl.handlers {
+		h.Close()
+	}
+}
+
+func newConfiguredHandler(hc HandlerConfig) (handler.Handler, error) {
+	switch strings.ToLower(hc.Type) {
+	case "stdout":
+		return handler.NewStdoutHandler()
+	case "stderr":
+		return handler.NewStderrHandler()
+	case "file":
+		size := handler.DefFileSize
+		if hc.MaxSize != "" {
+			s, err := parseByteSize(hc.MaxSize)
+			if err != nil {
+				return nil, err
+			}
+			size = s
+		}
+		rotate := byte(handler.DefRotatation)
+		if hc.Rotate != nil {
+			rotate = *hc.Rotate
+		}
+		var interval time.Duration
+		if hc.Interval != "" {
+			d, err := time.ParseDuration(hc.Interval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("Invalid interval %q", hc.Interval)
+			}
+			interval = d
+		}
+		if interval > 0 && !hc.Daily {
+			return handler.NewIntervalFileHandler(hc.Path, size, rotate, 1, hc.Compress, interval)
+		}
+		fh, err := handler.NewFileHandler(hc.Path, size, rotate, 1, hc.Compress, hc.Daily)
+		if err != nil {
+			return nil, err
+		}
+		if interval > 0 {
+			fh.SetRotateInterval(interval)
+		}
+		return fh, nil
+	case "syslog":
+		facility, ok := syslogFacilities[strings.ToLower(hc.Facility)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown syslog facility %q", hc.Facility)
+		}
+		severity := syslog.LOG_INFO
+		if hc.Severity != "" {
+			f, err := ParseSeverity(hc.Severity)
+			if err != nil {
+				return nil, err
+			}
+			severity, _ = syslogSeverity(f)
+		}
+		return handler.NewSyslogHandler(hc.Protocol, hc.Addr, facility|severity, hc.Tag)
+	default:
+		return nil, fmt.Errorf("Unknown handler type %q", hc.Type)
+	}
+}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"":         syslog.LOG_USER,
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+var syslogSeverities = map[SeverityFilter]syslog.Priority{
+	EmergSeverity:   syslog.LOG_EMERG,
+	AlertSeverity:   syslog.LOG_ALERT,
+	CritSeverity:    syslog.LOG_CRIT,
+	ErrSeverity:     syslog.LOG_ERR,
+	WarningSeverity: syslog.LOG_WARNING,
+	NoticeSeverity:  syslog.LOG_NOTICE,
+	InfoSeverity:    syslog.LOG_INFO,
+	DebugSeverity:   syslog.LOG_DEBUG,
+	TraceSeverity:   syslog.LOG_DEBUG,
+}
+
+// parseByteSize parses a size in bytes with an optional KB, MB or GB unit, e.g. "10MB".
+func parseByteSize(size string) (uint, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	unit := handler.ByteSize(1)
+	for _, u := range []struct {
+		suffix string
+		size   handler.ByteSize
+	}{{"KB", handler.KB}, {"MB", handler.MB}, {"GB", handler.GB}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q", size)
+	}
+	return uint(n * uint64(unit)), nil
+}
@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alyu/logger/handler"
+)
+
+func TestConfigureFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "audit.log")
+	cfg := `{
+  "loggers": {
+    "audit": {
+      "filter": "info, err",
+      "flags": 0,
+      "handlers": [
+        {"type": "file", "path": "` + logPath + `", "maxSize": "10MB", "rotate": 7, "compress": true}
+      ]
+    }
+  }
+}`
+	cfgPath := filepath.Join(dir, "logger.json")
+	if err := ioutil.WriteFile(cfgPath, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ConfigureFromFile(cfgPath); err != nil {
+		t.Fatalf("ConfigureFromFile failed: %v", err)
+	}
+
+	lg := Get("audit")
+	if lg.Flags() != 0 {
+		t.Errorf("Expected flags 0, got %d", lg.Flags())
+	}
+	if !lg.IsFilterSet(InfoSeverity|ErrSeverity) || lg.IsFilterSet(DebugSeverity) || lg.IsFilterSet(WarningSeverity) {
+		t.Errorf("Unexpected filter %d", lg.Filter())
+	}
+
+	hs := lg.Handlers()
+	if len(hs) != 1 {
+		t.Fatalf("Expected 1 handler, got %d", len(hs))
+	}
+	fh, ok := hs[0].(*handler.FileHandler)
+	if !ok {
+		t.Fatalf("Expected a FileHandler, got %v", hs[0])
+	}
+	if fh.Size() != uint(10*handler.MB) || fh.Rotate() != 7 || !fh.Compress() || fh.Daily() {
+		t.Errorf("Unexpected file handler settings: size %d rotate %d compress %v daily %v", fh.Size(), fh.Rotate(), fh.Compress(), fh.Daily())
+	}
+
+	lg.Err("written to the audit log")
+	b, _ := ioutil.ReadFile(logPath)
+	if !strings.Contains(string(b), "written to the audit log") {
+		t.Errorf("Expected the line in %s, got %q", logPath, b)
+	}
+}
+
+func TestConfigureUnknownHandler(t *testing.T) {
+	cfg := &Config{Loggers: map[string]LoggerConfig{
+		"unknown": {Handlers: []HandlerConfig{{Type: "kafka"}}},
+	}}
+	err := Configure(cfg)
+	if err == nil || !strings.Contains(err.Error(), `"kafka"`) {
+		t.Errorf("Expected an unknown handler type error, got %v", err)
+	}
+}
+
+func TestConfigureAllOrNothing(t *testing.T) {
+	keepRegistry(t)
+	existing := GetWithFlags("config_existing", 0)
+	existing.SetFilter(ErrSeverity)
+
+	cfg := &Config{Loggers: map[string]LoggerConfig{
+		"config_existing": {Filter: "all", Handlers: []HandlerConfig{{Type: "file", Path: filepath.Join(t.TempDir(), "a.log")}}},
+		"config_new":      {Handlers: []HandlerConfig{{Type: "stdout"}}},
+		"config_zbroken":  {Handlers: []HandlerConfig{{Type: "kafka"}}},
+	}}
+	if err := Configure(cfg); err == nil {
+		t.Fatal("Expected an error for the unknown handler type")
+	}
+	if existing.Filter() != ErrSeverity || len(existing.Handlers()) != 0 {
+		t.Errorf("Expected the existing logger to be unchanged, got filter %s and %v", filterNames(existing.Filter()), existing.Handlers())
+	}
+	for _, name := range List() {
+		if name == "config_new" || name == "config_zbroken" {
+			t.Errorf("Unexpected logger %q created", name)
+		}
+	}
+}
+
+func TestConfigureRotationTrigger(t *testing.T) {
+	dir := t.TempDir()
+	rotate, none := byte(5), byte(0)
+	for i, tc := range []struct {
+		hc    HandlerConfig
+		valid bool
+	}{
+		{HandlerConfig{MaxSize: "0", Rotate: &rotate}, false},
+		{HandlerConfig{MaxSize: "0", Rotate: &rotate, Daily: true}, true},
+		{HandlerConfig{MaxSize: "0", Rotate: &rotate, Interval: "1h"}, true},
+		{HandlerConfig{MaxSize: "0", Rotate: &none}, true},
+		{HandlerConfig{MaxSize: "1MB", Rotate: &rotate}, true},
+		{HandlerConfig{MaxSize: "0", Rotate: &rotate, Interval: "hourly"}, false},
+	} {
+		tc.hc.Type, tc.hc.Path = "file", filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		h, err := newConfiguredHandler(tc.hc)
+		if tc.valid != (err == nil) {
+			t.Errorf("%+v: expected valid %v, got error %v", tc.hc, tc.valid, err)
+		}
+		if h != nil {
+			h.Close()
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]uint{"1024": 1024, "10MB": uint(10 * handler.MB), "5 kb": uint(5 * handler.KB), "1GB": uint(handler.GB)} {
+		if got, err := parseByteSize(s); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("ten"); err == nil {
+		t.Error("Expected an error for an invalid size")
+	}
+}
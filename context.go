@@ -0,0 +1,139 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// NewContext returns a copy of ctx that carries the logger l.
+func NewContext(ctx context.Context, l *Logger4go) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger carried by ctx or the default logger if there is none.
+func FromContext(ctx context.Context) *Logger4go {
+	if l, ok := ctx.Value(loggerKey).(*Logger4go); ok {
+		return l
+	}
+	return Def()
+}
+
+// ContextWithRequestID returns a copy of ctx that carries a request ID.
+// Lines logged with the Ctx methods get it as a request_id field.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// TraceExtractor returns the trace and span IDs of the span active in ctx, or "" if there
+// is none, e.g. with OpenTelemetry:
+//
+//	logger.SetTraceExtractor(func(ctx context.Context) (string, string) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		if !sc.IsValid() {
+//			return "", ""
+//		}
+//		return sc.TraceID().String(), sc.SpanID().String()
+//	})
+type TraceExtractor func(ctx context.Context) (traceID, spanID string)
+
+var traceExtractor atomic.Value
+
+// SetTraceExtractor sets the function used by the Ctx methods of all loggers to add the
+// trace_id and span_id fields, correlating the lines with the distributed traces.
+// A nil function stops adding them.
+func SetTraceExtractor(extract TraceExtractor) {
+	traceExtractor.Store(extract)
+}
+
+// EmergCtx log with the fields carried by ctx
+func (l *Logger4go) EmergCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, EmergSeverity, v...)
+}
+
+// AlertCtx log with the fields carried by ctx
+func (l *Logger4go) AlertCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, AlertSeverity, v...)
+}
+
+// CritCtx log with the fields carried by ctx
+func (l *Logger4go) CritCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, CritSeverity, v...)
+}
+
+// ErrCtx log with the fields carried by ctx
+func (l *Logger4go) ErrCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, ErrSeverity, v...)
+}
+
+// WarningCtx log with the fields carried by ctx
+func (l *Logger4go) WarningCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, WarningSeverity, v...)
+}
+
+// NoticeCtx log with the fields carried by ctx
+func (l *Logger4go) NoticeCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, NoticeSeverity, v...)
+}
+
+// InfoCtx log with the fields carried by ctx
+func (l *Logger4go) InfoCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, InfoSeverity, v...)
+}
+
+// DebugCtx log with the fields carried by ctx
+func (l *Logger4go) DebugCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, DebugSeverity, v...)
+}
+
+// TraceCtx log with the fields carried by ctx
+func (l *Logger4go) TraceCtx(ctx context.Context, v ...interface{}) {
+	l.logCtx(ctx, TraceSeverity, v...)
+}
+
+// logCtx logs with the fields carried by ctx, which are only collected for a line that is written.
+func (l *Logger4go) logCtx(ctx context.Context, f SeverityFilter, v ...interface{}) {
+	if l.IsEnabled() && l.IsFilterSet(f) && l.rateAllowed(f) {
+		l.output(f, contextFields(ctx), fmt.Sprintf("%s", v...))
+	}
+}
+
+// contextFields returns the log fields carried by ctx. If ctx has a deadline the
+// time left until it expires is added as deadline_remaining, negative once passed.
+// The trace and span IDs are added if a TraceExtractor is set.
+func contextFields(ctx context.Context) Fields {
+	f := Fields{}
+	if id := RequestIDFromContext(ctx); id != "" {
+		f["request_id"] = id
+	}
+	if extract, ok := traceExtractor.Load().(TraceExtractor); ok && extract != nil {
+		traceID, spanID := extract(ctx)
+		if traceID != "" {
+			f["trace_id"] = traceID
+		}
+		if spanID != "" {
+			f["span_id"] = spanID
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		f["deadline_remaining"] = deadline.Sub(now())
+	}
+	return f
+}
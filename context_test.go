@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadlineRemaining(t *testing.T) {
+	lg := Get("ctx")
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	lg.InfoCtx(ctx, "waiting")
+
+	line := buf.String()
+	i := strings.Index(line, "deadline_remaining=")
+	if i < 0 {
+		t.Fatalf("Missing deadline_remaining in %q", line)
+	}
+	remaining, err := time.ParseDuration(strings.Fields(line[i+len("deadline_remaining="):])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining <= time.Second || remaining > 2*time.Second {
+		t.Errorf("Expected roughly 2s remaining, got %v", remaining)
+	}
+
+	buf.Reset()
+	lg.InfoCtx(context.Background(), "no deadline")
+	if strings.Contains(buf.String(), "deadline_remaining") {
+		t.Errorf("Unexpected deadline_remaining for a context without deadline: %q", buf.String())
+	}
+}
+
+func TestDeadlineRemainingClock(t *testing.T) {
+	SetClock(fixedClock)
+	defer SetClock(nil)
+	lg := Get("ctx")
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	ctx, cancel := context.WithDeadline(context.Background(), fixedClock().Add(90*time.Second))
+	defer cancel()
+	lg.InfoCtx(ctx, "waiting")
+	if !strings.Contains(buf.String(), "deadline_remaining=1m30s") {
+		t.Errorf("Expected deadline_remaining measured from the package clock, got %q", buf.String())
+	}
+}
+
+func TestTraceExtractor(t *testing.T) {
+	type spanKey struct{}
+	SetTraceExtractor(func(ctx context.Context) (string, string) {
+		if span, ok := ctx.Value(spanKey{}).(string); ok {
+			return "4bf92f3577b34da6a3ce929d0e0e4736", span
+		}
+		return "", ""
+	})
+	defer SetTraceExtractor(nil)
+
+	lg := GetWithFlags("traced", 0)
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+	ctx := context.WithValue(context.Background(), spanKey{}, "00f067aa0ba902b7")
+
+	lg.InfoCtx(ctx, "text")
+	for _, want := range []string{"trace_id=4bf92f3577b34da6a3ce929d0e0e4736", "span_id=00f067aa0ba902b7"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Missing %s in %q", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	lg.SetFormatter(&JSONFormatter{})
+	lg.InfoCtx(ctx, "json")
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || m["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("Unexpected line %s", buf.String())
+	}
+
+	buf.Reset()
+	lg.InfoCtx(context.Background(), "no span")
+	if strings.Contains(buf.String(), "trace_id") {
+		t.Errorf("Unexpected trace_id without a span: %s", buf.String())
+	}
+}
+
+func TestCtxFiltered(t *testing.T) {
+	calls := 0
+	SetTraceExtractor(func(ctx context.Context) (string, string) {
+		calls++
+		return "trace", "span"
+	})
+	defer SetTraceExtractor(nil)
+
+	lg := GetWithFlags("ctx_filtered", 0)
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+	lg.SetMinLevel(InfoSeverity)
+
+	lg.DebugCtx(context.Background(), "filtered")
+	lg.TraceCtx(context.Background(), "filtered")
+	if calls != 0 || buf.Len() != 0 {
+		t.Errorf("Expected no trace extraction for filtered lines, got %d calls and %q", calls, buf.String())
+	}
+	lg.InfoCtx(context.Background(), "written")
+	if calls != 1 {
+		t.Errorf("Expected one trace extraction, got %d", calls)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ConfigureFromEnv sets up loggers from environment variables named
+// <prefix>_<LOGGER>_<SETTING>, where the logger name is lower cased. Supported settings are
+//
+//	LEVEL  comma separated severity names, e.g. LOGGER_MAIN_LEVEL=info,debug
+//	FILE   path to log to with a standard file handler, e.g. LOGGER_MAIN_FILE=/tmp/app.log
+//
+// An error listing all unrecognized severity names is returned without configuring anything.
+func ConfigureFromEnv(prefix string) error {
+	cfg := &Config{Loggers: make(map[string]LoggerConfig)}
+	var unknown []string
+
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		key, value := kv[:i], kv[i+1:]
+		if !strings.HasPrefix(key, prefix+"_") || value == "" {
+			continue
+		}
+		key = strings.TrimPrefix(key, prefix+"_")
+
+		j := strings.LastIndexByte(key, '_')
+		if j <= 0 {
+			continue
+		}
+		name, setting := strings.ToLower(key[:j]), key[j+1:]
+
+		lc := cfg.Loggers[name]
+		switch setting {
+		case "LEVEL":
+			for _, s := range strings.Split(value, ",") {
+				if _, err := ParseSeverity(s); err != nil {
+					unknown = append(unknown, strings.TrimSpace(s))
+				}
+			}
+			lc.Filter = value
+		case "FILE":
+			lc.Handlers = append(lc.Handlers, HandlerConfig{Type: "file", Path: value})
+		default:
+			continue
+		}
+		cfg.Loggers[name] = lc
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown severity names in %s_*_LEVEL: %s", prefix, strings.Join(unknown, ", "))
+	}
+	return Configure(cfg)
+}
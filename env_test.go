@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alyu/logger/handler"
+)
+
+func TestConfigureFromEnv(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	t.Setenv("LOGTEST_APP_LEVEL", "warning, ERR")
+	t.Setenv("LOGTEST_APP_FILE", logPath)
+
+	if err := ConfigureFromEnv("LOGTEST"); err != nil {
+		t.Fatalf("ConfigureFromEnv failed: %v", err)
+	}
+
+	lg := Get("app")
+	if !lg.IsFilterSet(WarningSeverity|ErrSeverity) || lg.IsFilterSet(InfoSeverity) {
+		t.Errorf("Unexpected filter %d", lg.Filter())
+	}
+
+	hs := lg.Handlers()
+	if len(hs) != 1 {
+		t.Fatalf("Expected 1 handler, got %d", len(hs))
+	}
+	if _, ok := hs[0].(*handler.FileHandler); !ok {
+		t.Errorf("Expected a FileHandler, got %v", hs[0])
+	}
+}
+
+func TestConfigureFromEnvUnknownLevels(t *testing.T) {
+	t.Setenv("LOGTEST2_APP_LEVEL", "info,verbose,chatty")
+
+	err := ConfigureFromEnv("LOGTEST2")
+	if err == nil {
+		t.Fatal("Expected an error for unknown level names")
+	}
+	if !strings.Contains(err.Error(), "chatty, verbose") {
+		t.Errorf("Expected all unknown names in the error, got %v", err)
+	}
+}
@@ -89,3 +89,27 @@ func Example() {
 	lg.Info("Stderr always has a console handler and prefix 'err'")
 	lg.Err("Writes to stderr")
 }
+
+func ExampleLogger4go_RouteFormat() {
+	lg := logger.Get("mixed")
+	fh, err := lg.AddStdFileHandler("/tmp/mixed.log")
+	if err != nil {
+		_ = fmt.Errorf("%v", err)
+	}
+
+	// info lines are written as text, err lines as JSON to the same file
+	lg.RouteFormat(logger.ErrSeverity, fh, &logger.JSONFormatter{})
+	lg.Info("Written as text")
+	lg.Err("Written as JSON")
+}
+
+// logger.MB and handler.MB are the same type and interchangeable.
+var _ handler.ByteSize = logger.MB
+var _ logger.ByteSize = handler.MB
+
+func ExampleByteSize() {
+	lg := logger.Get("sizes")
+	lg.AddFileHandler("/tmp/sizes.log", uint(5*logger.MB), 5, false, false)
+	fh, _ := lg.AddFileHandler("/tmp/sizes2.log", uint(5*handler.MB), 5, false, false)
+	fh.SetSize(uint(logger.MB + handler.KB))
+}
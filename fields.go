@@ -0,0 +1,111 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fields are key/value pairs attached to a log line. In text output they are
+// appended after the message as key=value pairs sorted by key.
+type Fields map[string]interface{}
+
+// String returns the fields as space separated key=value pairs sorted by key.
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(fieldValue(f[k]))
+	}
+	return sb.String()
+}
+
+// LazyValue is a field value that is computed only when the line is written.
+type LazyValue struct {
+	fn func() interface{}
+}
+
+// Lazy returns a field value computed by fn. fn is only called if the line
+// passes the severity filter, so expensive values cost nothing for filtered
+// out lines.
+func Lazy(fn func() interface{}) LazyValue {
+	return LazyValue{fn}
+}
+
+// resolveFields returns fields with lazy values computed. fields is returned
+// as is if it has no lazy values.
+func resolveFields(fields Fields) Fields {
+	var resolved Fields
+	for k, v := range fields {
+		if lv, ok := v.(LazyValue); ok {
+			if resolved == nil {
+				resolved = mergeFields(fields)
+			}
+			resolved[k] = lv.fn()
+		}
+	}
+	if resolved == nil {
+		return fields
+	}
+	return resolved
+}
+
+// mergeFields returns a new Fields with all keys of fs, later keys win.
+func mergeFields(fs ...Fields) Fields {
+	m := make(Fields)
+	for _, f := range fs {
+		for k, v := range f {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// fieldValue renders a field value, quoting it if it contains spaces, quotes or '='.
+// Booleans and numbers are rendered without fmt.
+func fieldValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"log/syslog"
+	"sync/atomic"
+
+	"github.com/alyu/logger/handler"
+)
+
+// FilteringHandler wraps a handler with its own severity filter, e.g. to write all
+// lines to a file but only warnings and above to stderr. A line is written to the
+// handler if both the logger's and the handler's filter are set for its severity.
+type FilteringHandler struct {
+	handler.Handler
+	filter int32 // SeverityFilter, accessed atomically
+}
+
+// NewFilteringHandler returns h filtered by f.
+func NewFilteringHandler(h handler.Handler, f SeverityFilter) *FilteringHandler {
+	return &FilteringHandler{Handler: h, filter: int32(f)}
+}
+
+// IsFilterSet returns true if the severity filter is set
+func (fh *FilteringHandler) IsFilterSet(f SeverityFilter) bool {
+	return f&fh.Filter() == f
+}
+
+// Filter returns the severity filter
+func (fh *FilteringHandler) Filter() SeverityFilter {
+	return SeverityFilter(atomic.LoadInt32(&fh.filter))
+}
+
+// SetFilter sets a severity filter
+func (fh *FilteringHandler) SetFilter(f SeverityFilter) {
+	atomic.StoreInt32(&fh.filter, int32(f))
+}
+
+// WriteSeverity passes the severity on to the wrapped handler if it makes use of it.
+func (fh *FilteringHandler) WriteSeverity(severity syslog.Priority, b []byte) (int, error) {
+	if sw, ok := fh.Handler.(severityWriter); ok {
+		return sw.WriteSeverity(severity, b)
+	}
+	return fh.Handler.Write(b)
+}
+
+// WriteLevel passes the level on to the wrapped handler if it makes use of it.
+func (fh *FilteringHandler) WriteLevel(level SeverityFilter, b []byte) (int, error) {
+	return writeHandler(fh.Handler, level, b)
+}
+
+// Verify verifies the wrapped handler.
+func (fh *FilteringHandler) Verify() error {
+	return verifyHandler(fh.Handler)
+}
+
+// Flush flushes the wrapped handler.
+func (fh *FilteringHandler) Flush() error {
+	return handler.Flush(fh.Handler)
+}
+
+// String returns the handler name.
+func (fh *FilteringHandler) String() string {
+	return "FilteringHandler(" + fh.Handler.String() + ")"
+}
+
+// Describe returns the description of the wrapped handler and the severities it writes.
+func (fh *FilteringHandler) Describe() string {
+	return handler.Describe(fh.Handler) + " [" + filterNames(fh.Filter()) + "]"
+}
+
+// AddFilteredHandler adds h with its own severity filter f.
+func (l *Logger4go) AddFilteredHandler(h handler.Handler, f SeverityFilter) (fh *FilteringHandler, err error) {
+	fh = NewFilteringHandler(h, f)
+	if err = registerHandler(l, fh); err != nil {
+		return nil, err
+	}
+	return fh, nil
+}
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alyu/logger/handler"
+)
+
+func TestFilteringHandler(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	path := filepath.Join(t.TempDir(), "all.log")
+	lg := GetWithFlags("per_handler", 0)
+	fh, err := lg.AddFileHandler(path, 0, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	if _, err = lg.AddFilteredHandler(&handler.StderrHandler{}, SeverityAtLeast(WarningSeverity)); err != nil {
+		t.Fatal(err)
+	}
+
+	lg.Debug("debug line")
+	lg.Warning("warning line")
+	w.Close()
+	os.Stderr = stderr
+
+	b, _ := io.ReadAll(r)
+	if want := "per_handler  warning  warning line\n"; string(b) != want {
+		t.Errorf("Unexpected stderr output:\n got  %q\n want %q", b, want)
+	}
+	b, _ = os.ReadFile(path)
+	if !strings.Contains(string(b), "debug line") || !strings.Contains(string(b), "warning line") {
+		t.Errorf("Expected all lines in the file: %q", b)
+	}
+}
+
+func TestFuncHandlerHook(t *testing.T) {
+	lg := GetWithFlags("hook", 0)
+	var calls []string
+	hook := handler.NewFuncHandler(func(b []byte) (int, error) {
+		calls = append(calls, string(b))
+		return len(b), nil
+	})
+	fh, err := lg.AddFilteredHandler(hook, CritSeverity|EmergSeverity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lg.RemoveHandler(fh)
+
+	lg.Info("not a hook")
+	lg.Crit("disk failure")
+	lg.Crit("disk failure again")
+	lg.Emerg("power failure")
+
+	if len(calls) != 3 {
+		t.Fatalf("Expected the hook to fire once per crit or emerg line, got %q", calls)
+	}
+	if want := "hook  crit     disk failure\n"; calls[0] != want {
+		t.Errorf("Unexpected hook input:\n got  %q\n want %q", calls[0], want)
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is a log line before it is formatted.
+type Entry struct {
+	Time    time.Time
+	Level   SeverityFilter
+	Name    string // logger name
+	Prefix  string // logger prefix
+	Flags   int    // logger header flags, see log.LstdFlags
+	Message string
+	Fields  Fields
+	Static  []string // key/value pairs set with SetStaticFields, in order
+	File    string   // caller file, only set if Flags has log.Lshortfile or log.Llongfile
+	Line    int      // caller line
+	Func    string   // caller function, e.g. "main.handleRequest"
+}
+
+// Formatter renders an Entry to the bytes written to the handlers.
+type Formatter interface {
+	Format(e *Entry) ([]byte, error)
+}
+
+// TextFormatter is the default formatter. It writes the same header as log.Logger
+// followed by the severity keyword, the message and the fields as key=value pairs.
+//
+//	main 2013/06/21 08:22:14  info     An info message key=value
+//
+// If Template is set the line is laid out by the template instead, replacing the
+// tokens {time}, {level}, {logger}, {prefix}, {caller}, {msg} and {fields}. The fields
+// are appended to the line if the template has no {fields}. The header flags are not
+// used except for the caller, which is only known with log.Lshortfile or log.Llongfile.
+//
+//	&logger.TextFormatter{Template: "[{level}] {time} {logger}: {msg}", TimeLayout: time.RFC3339}
+type TextFormatter struct {
+	Template   string // line layout, "" for the log.Logger layout
+	TimeLayout string // layout of {time}, "2006/01/02 15:04:05" if empty
+}
+
+// Format renders e as a text line.
+func (tf *TextFormatter) Format(e *Entry) ([]byte, error) {
+	if tf.Template != "" {
+		return tf.formatTemplate(e), nil
+	}
+
+	var buf bytes.Buffer
+	if e.Flags&log.Lmsgprefix == 0 {
+		buf.WriteString(e.Prefix)
+	}
+	formatHeader(&buf, e)
+	if e.Flags&log.Lmsgprefix != 0 {
+		buf.WriteString(e.Prefix)
+	}
+
+	writeLevelColumn(&buf, e.Level)
+	buf.WriteByte(' ')
+	buf.WriteString(e.Message)
+	if fields := textFields(e); fields != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(fields)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// formatTemplate renders e with the template.
+func (tf *TextFormatter) formatTemplate(e *Entry) []byte {
+	var buf bytes.Buffer
+	fields := false
+	for t := tf.Template; t != ""; {
+		i := strings.IndexByte(t, '{')
+		if i < 0 {
+			buf.WriteString(t)
+			break
+		}
+		buf.WriteString(t[:i])
+		t = t[i:]
+		j := strings.IndexByte(t, '}')
+		if j < 0 {
+			buf.WriteString(t)
+			break
+		}
+
+		switch token := t[:j+1]; token {
+		case "{time}":
+			layout := tf.TimeLayout
+			if layout == "" {
+				layout = "2006/01/02 15:04:05"
+			}
+			buf.WriteString(entryTime(e).Format(layout))
+		case "{level}":
+			buf.WriteString(e.Level.String())
+		case "{logger}":
+			buf.WriteString(e.Name)
+		case "{prefix}":
+			buf.WriteString(e.Prefix)
+		case "{caller}":
+			if e.File != "" {
+				buf.WriteString(caller(e))
+			}
+		case "{msg}":
+			buf.WriteString(strings.TrimSuffix(e.Message, "\n"))
+		case "{fields}":
+			buf.WriteString(textFields(e))
+			fields = true
+		default:
+			buf.WriteString(token)
+		}
+		t = t[j+1:]
+	}
+
+	if s := textFields(e); !fields && s != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(s)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// textFields returns the static fields in order followed by the fields sorted by key,
+// as key=value pairs.
+func textFields(e *Entry) string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(e.Static); i += 2 {
+		if _, ok := e.Fields[e.Static[i]]; ok {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(e.Static[i])
+		sb.WriteByte('=')
+		sb.WriteString(fieldValue(e.Static[i+1]))
+	}
+	if len(e.Fields) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(e.Fields.String())
+	}
+	return sb.String()
+}
+
+// levelColumns holds the level column of each level, see buildLevelColumns.
+var levelColumns atomic.Value
+
+// buildLevelColumns precomputes the level column of each level, the name padded to the
+// longest name with a space on either side, so that formatting a line needs no lookup of
+// the width. It is rebuilt when a level is registered.
+func buildLevelColumns() map[SeverityFilter][]byte {
+	order := severities()
+	width := 0
+	for _, s := range order {
+		if n := len(s.String()); n > width {
+			width = n
+		}
+	}
+	columns := make(map[SeverityFilter][]byte, len(order))
+	for _, s := range order {
+		columns[s] = []byte(" " + s.String() + strings.Repeat(" ", width-len(s.String())+1))
+	}
+	levelColumns.Store(columns)
+	return columns
+}
+
+// writeLevelColumn writes the severity name padded to the longest name, with a space
+// on either side, so that the messages line up, e.g. " info    ".
+func writeLevelColumn(buf *bytes.Buffer, level SeverityFilter) {
+	columns, ok := levelColumns.Load().(map[SeverityFilter][]byte)
+	if !ok {
+		columns = buildLevelColumns()
+	}
+	if column, ok := columns[level]; ok {
+		buf.Write(column)
+		return
+	}
+	// Not a single level, e.g. a combined filter, pad it like the others.
+	name := level.String()
+	width := len(columns[DebugSeverity]) - 2
+	buf.WriteByte(' ')
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	for i := len(name); i < width; i++ {
+		buf.WriteByte(' ')
+	}
+}
+
+// formatHeader writes the date, time and caller the same way as log.Logger.
+func formatHeader(buf *bytes.Buffer, e *Entry) {
+	t := entryTime(e)
+	var scratch [32]byte // formats the time without allocating a string
+	if e.Flags&log.Ldate != 0 {
+		buf.Write(t.AppendFormat(scratch[:0], "2006/01/02 "))
+	}
+	if e.Flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		if e.Flags&log.Lmicroseconds != 0 {
+			buf.Write(t.AppendFormat(scratch[:0], "15:04:05.000000 "))
+		} else {
+			buf.Write(t.AppendFormat(scratch[:0], "15:04:05 "))
+		}
+	}
+	if e.Flags&(log.Lshortfile|log.Llongfile) != 0 {
+		buf.WriteString(caller(e))
+		buf.WriteString(": ")
+	}
+}
+
+// entryTime returns the time of e, in UTC if the flags have log.LUTC.
+func entryTime(e *Entry) time.Time {
+	if e.Flags&log.LUTC != 0 {
+		return e.Time.UTC()
+	}
+	return e.Time
+}
+
+// caller returns the caller file and line, e.g. "main.go:42", with the full path
+// unless the flags have log.Lshortfile.
+func caller(e *Entry) string {
+	file := e.File
+	if e.Flags&log.Lshortfile != 0 {
+		if i := strings.LastIndexByte(file, '/'); i >= 0 {
+			file = file[i+1:]
+		}
+	}
+	return file + ":" + strconv.Itoa(e.Line)
+}
+
+// JSONFormatter writes one JSON object per line with the keys time (RFC 3339),
+// level, logger and msg followed by the static fields in order and the fields sorted by key.
+//
+//	{"time":"2013-06-21T08:22:14.680513+02:00","level":"info","logger":"main","msg":"An info message","key":"value"}
+//
+// If the logger flags have log.Lshortfile or log.Llongfile the call site is added as
+// caller, e.g. "caller":"main.go:42", and the calling function as func if CallerFunc is set.
+// The object is written on a single line unless Pretty is set.
+//
+// The time key and layout can be changed with TimeKey and TimeLayout. The layout is a
+// time.Format layout, e.g. time.RFC3339, or TimeEpochMillis or TimeEpochNanos for the
+// time since the Unix epoch as a JSON number.
+//
+//	&logger.JSONFormatter{TimeKey: "ts", TimeLayout: logger.TimeEpochMillis}
+type JSONFormatter struct {
+	Pretty     bool   // indent the object over several lines, e.g. for reading during development
+	CallerFunc bool   // add the caller function name
+	TimeKey    string // key of the time, "time" if empty
+	TimeLayout string // layout of the time, time.RFC3339Nano if empty
+}
+
+// Time layouts of the JSONFormatter for the time since the Unix epoch as a number.
+const (
+	TimeEpochMillis = "epoch_millis"
+	TimeEpochNanos  = "epoch_nanos"
+)
+
+// timeValue returns the time of e as set by the layout.
+func (jf *JSONFormatter) timeValue(e *Entry) interface{} {
+	t := entryTime(e)
+	switch jf.TimeLayout {
+	case "":
+		return t.Format(time.RFC3339Nano)
+	case TimeEpochMillis:
+		return t.UnixNano() / int64(time.Millisecond)
+	case TimeEpochNanos:
+		return t.UnixNano()
+	default:
+		return t.Format(jf.TimeLayout)
+	}
+}
+
+// Format renders e as a JSON object.
+func (jf *JSONFormatter) Format(e *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	timeKey := jf.TimeKey
+	if timeKey == "" {
+		timeKey = "time"
+	}
+	writeJSONField(&buf, timeKey, jf.timeValue(e), true)
+	writeJSONField(&buf, "level", e.Level.String(), false)
+	writeJSONField(&buf, "logger", e.Name, false)
+	writeJSONField(&buf, "msg", e.Message, false)
+	if e.File != "" {
+		writeJSONField(&buf, "caller", caller(e), false)
+		if jf.CallerFunc && e.Func != "" {
+			writeJSONField(&buf, "func", e.Func, false)
+		}
+	}
+
+	for i := 0; i+1 < len(e.Static); i += 2 {
+		if _, ok := e.Fields[e.Static[i]]; !ok {
+			writeJSONField(&buf, e.Static[i], e.Static[i+1], false)
+		}
+	}
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeJSONField(&buf, k, jsonValue(e.Fields[k]), false)
+	}
+	buf.WriteByte('}')
+	if jf.Pretty {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, buf.Bytes(), "", "  "); err != nil {
+			return nil, err
+		}
+		buf = pretty
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, v interface{}, first bool) {
+	if !first {
+		buf.WriteByte(',')
+	}
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+
+	var num [32]byte
+	if b, ok := appendJSONNumber(num[:0], v); ok {
+		buf.Write(b)
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+// appendJSONNumber appends booleans and numbers to b without reflection. NaN and
+// infinities, which JSON can't represent, are appended as the strings "NaN", "+Inf"
+// and "-Inf". It returns false for other types.
+func appendJSONNumber(b []byte, v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case bool:
+		return strconv.AppendBool(b, v), true
+	case int:
+		return strconv.AppendInt(b, int64(v), 10), true
+	case int64:
+		return strconv.AppendInt(b, v, 10), true
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10), true
+	case uint:
+		return strconv.AppendUint(b, uint64(v), 10), true
+	case uint64:
+		return strconv.AppendUint(b, v, 10), true
+	case uint32:
+		return strconv.AppendUint(b, uint64(v), 10), true
+	case float64:
+		return appendJSONFloat(b, v, 64), true
+	case float32:
+		return appendJSONFloat(b, float64(v), 32), true
+	}
+	return b, false
+}
+
+// appendJSONFloat appends f the way encoding/json does.
+func appendJSONFloat(b []byte, f float64, bits int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		b = append(b, '"')
+		b = strconv.AppendFloat(b, f, 'g', -1, bits)
+		return append(b, '"')
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+// jsonValue renders errors and Stringers, e.g. time.Duration, as their string value.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// Decorator changes an Entry before it is rendered, e.g. to add a computed field.
+type Decorator func(e *Entry)
+
+type chainFormatter struct {
+	formatter  Formatter
+	decorators []Decorator
+}
+
+// ChainFormatter returns a Formatter that runs the decorators in order and then renders
+// the entry with f. The decorators get a copy of the entry and its fields, and f may
+// itself be a ChainFormatter.
+//
+//	lg.SetFormatter(logger.ChainFormatter(&logger.JSONFormatter{}, addHostname))
+func ChainFormatter(f Formatter, decorators ...Decorator) Formatter {
+	return &chainFormatter{formatter: f, decorators: decorators}
+}
+
+// Format decorates a copy of e and renders it.
+func (cf *chainFormatter) Format(e *Entry) ([]byte, error) {
+	c := *e
+	c.Fields = mergeFields(e.Fields)
+	for _, d := range cf.decorators {
+		d(&c)
+	}
+	return cf.formatter.Format(&c)
+}
+
+// WithLevelFields returns a Decorator adding fields to lines of minLevel or more severe,
+// e.g. to attach a request dump to err lines only. Lazy values are only computed for
+// those lines.
+//
+//	lg.SetFormatter(logger.ChainFormatter(&logger.TextFormatter{},
+//		logger.WithLevelFields(logger.ErrSeverity, logger.Fields{"dump": logger.Lazy(dump)})))
+func WithLevelFields(minLevel SeverityFilter, fields Fields) Decorator {
+	return func(e *Entry) {
+		if e.Level&SeverityAtLeast(minLevel) == 0 {
+			return
+		}
+		for k, v := range resolveFields(fields) {
+			e.Fields[k] = v
+		}
+	}
+}
+
+var clock atomic.Value
+
+// SetClock sets the function used to timestamp log entries in all loggers.
+// It is primarily meant for tests that need reproducible output.
+// A nil function restores time.Now.
+func SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	clock.Store(now)
+}
+
+func now() time.Time {
+	if fn, ok := clock.Load().(func() time.Time); ok {
+		return fn()
+	}
+	return time.Now()
+}
@@ -0,0 +1,488 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fixedClock() time.Time {
+	return time.Date(2013, 6, 21, 8, 21, 44, 680513000, time.FixedZone("CEST", 2*3600))
+}
+
+func TestGoldenText(t *testing.T) {
+	SetClock(fixedClock)
+	defer SetClock(nil)
+
+	lg := GetWithFlags("golden", log.LstdFlags|log.Lmicroseconds)
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	lg.Info("init called")
+	lg.logFields(ErrSeverity, Fields{"b": 2, "a": "x y"}, "failed %d times", 3)
+
+	want := "golden 2013/06/21 08:21:44.680513  info     init called\n" +
+		"golden 2013/06/21 08:21:44.680513  err      failed 3 times a=\"x y\" b=2\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+	}
+}
+
+func TestLevelColumn(t *testing.T) {
+	if s := InfoSeverity.String(); s != "info" {
+		t.Errorf("Expected the bare name info, got %q", s)
+	}
+
+	lg := GetWithFlags("aligned", 0)
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+	for _, s := range severityOrder {
+		lg.doPrintf(s, "message")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(severityOrder) {
+		t.Fatalf("Expected %d lines, got %q", len(severityOrder), lines)
+	}
+	for i, line := range lines {
+		if col := strings.Index(line, "message"); col != len("aligned  warning  ") {
+			t.Errorf("Message not aligned at column %d: %q", col, line)
+		}
+		if !strings.HasPrefix(line, "aligned  "+severityOrder[i].String()+" ") {
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+}
+
+func TestTextTemplate(t *testing.T) {
+	SetClock(fixedClock)
+	defer SetClock(nil)
+
+	lg := GetWithFlags("templated", log.Lshortfile)
+	lg.SetFormatter(&TextFormatter{Template: "[{level}] {time} {logger}: {msg} ({caller}) {unknown}", TimeLayout: time.RFC3339})
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	_, _, line, _ := runtime.Caller(0)
+	lg.Errf("failed %d times", 3)
+	lg.SetFormatter(&TextFormatter{Template: "{level}|{fields}|{msg}|{time}"})
+	lg.logFields(InfoSeverity, Fields{"n": 4}, "done")
+	// fields are appended without {fields}
+	lg.SetFormatter(&TextFormatter{Template: "{level} {msg}"})
+	lg.logFields(InfoSeverity, Fields{"n": 5}, "done")
+
+	want := fmt.Sprintf("[err] 2013-06-21T08:21:44+02:00 templated: failed 3 times (formatter_test.go:%d) {unknown}\n", line+1) +
+		"info|n=4|done|2013/06/21 08:21:44\n" +
+		"info done n=5\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+	}
+}
+
+func TestGoldenJSON(t *testing.T) {
+	SetClock(fixedClock)
+	defer SetClock(nil)
+
+	lg := Get("golden-json")
+	lg.SetFormatter(&JSONFormatter{})
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	lg.logFields(WarningSeverity, Fields{"took": 1500 * time.Millisecond, "n": 1}, "slow")
+
+	want := `{"time":"2013-06-21T08:21:44.680513+02:00","level":"warning","logger":"golden-json","msg":"slow","n":1,"took":"1.5s"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+	}
+}
+
+func TestUTC(t *testing.T) {
+	SetClock(fixedClock)
+	defer SetClock(nil)
+
+	l := GetWithFlags("utc", log.Ldate|log.Ltime)
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+	l.SetUTC(true)
+	if !l.IsUTC() {
+		t.Fatal("Expected IsUTC after SetUTC")
+	}
+
+	l.Info("text")
+	l.SetFormatter(&JSONFormatter{})
+	l.Info("json")
+	want := "utc 2013/06/21 06:21:44  info     text\n" +
+		`{"time":"2013-06-21T06:21:44.680513Z","level":"info","logger":"utc","msg":"json"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+	}
+}
+
+func TestJSONTimeLayout(t *testing.T) {
+	e := &Entry{Time: fixedClock(), Level: InfoSeverity, Name: "main", Message: "m"}
+	for _, tc := range []struct {
+		key, layout, want string
+	}{
+		{"", "", `"time":"2013-06-21T08:21:44.680513+02:00"`},
+		{"ts", time.RFC3339, `"ts":"2013-06-21T08:21:44+02:00"`},
+		{"", time.RFC3339Nano, `"time":"2013-06-21T08:21:44.680513+02:00"`},
+		{"ts", TimeEpochMillis, `"ts":1371795704680`},
+		{"", TimeEpochNanos, `"time":1371795704680513000`},
+	} {
+		b, err := (&JSONFormatter{TimeKey: tc.key, TimeLayout: tc.layout}).Format(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(b, []byte("{"+tc.want+",")) {
+			t.Errorf("Layout %q: expected %s, got %s", tc.layout, tc.want, b)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		key := tc.key
+		if key == "" {
+			key = "time"
+		}
+		_, isNum := m[key].(float64)
+		if epoch := strings.HasPrefix(tc.layout, "epoch"); isNum != epoch {
+			t.Errorf("Layout %q: expected a number %v, got %T", tc.layout, epoch, m[key])
+		}
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	e := &Entry{Time: fixedClock(), Level: InfoSeverity, Name: "pretty", Message: "multi\nline", Fields: Fields{"n": 1}}
+
+	compact, err := (&JSONFormatter{}).Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(compact, []byte("\n")) != 1 || !bytes.HasSuffix(compact, []byte("\n")) {
+		t.Errorf("Expected a single line, got %q", compact)
+	}
+
+	pretty, err := (&JSONFormatter{Pretty: true}).Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n" +
+		"  \"time\": \"2013-06-21T08:21:44.680513+02:00\",\n" +
+		"  \"level\": \"info\",\n" +
+		"  \"logger\": \"pretty\",\n" +
+		"  \"msg\": \"multi\\nline\",\n" +
+		"  \"n\": 1\n" +
+		"}\n"
+	if string(pretty) != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", pretty, want)
+	}
+	var c, p map[string]interface{}
+	if err := json.Unmarshal(compact, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(pretty, &p); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if fmt.Sprint(c) != fmt.Sprint(p) {
+		t.Errorf("Pretty and compact records differ:\n %v\n %v", p, c)
+	}
+}
+
+func TestCallerFile(t *testing.T) {
+	lg := GetWithFlags("caller", log.Lshortfile)
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	lg.Info("where")
+	if want := "caller formatter_test.go:"; !bytes.HasPrefix(buf.Bytes(), []byte(want)) {
+		t.Errorf("Expected the caller to be this file: %q", buf.String())
+	}
+}
+
+func TestCallerField(t *testing.T) {
+	lg := GetWithFlags("caller-json", log.Lshortfile)
+	lg.SetFormatter(&JSONFormatter{CallerFunc: true})
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	_, _, line, _ := runtime.Caller(0)
+	lg.Infof("where %d", 1)
+	// package functions report the call site as well
+	InitDefaults()
+	flags, formatter := Logger.Flags(), Logger.Formatter()
+	Logger.SetFlags(log.Llongfile)
+	Logger.SetFormatter(&JSONFormatter{})
+	Logger.SetOutput(&buf)
+	Info("where 2")
+	Logger.SetOutput(Logger.mw)
+	Logger.SetFlags(flags)
+	Logger.SetFormatter(formatter)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	var first, second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("formatter_test.go:%d", line+1); first["caller"] != want {
+		t.Errorf("Expected caller %q, got %v", want, first["caller"])
+	}
+	if want := "github.com/alyu/logger.TestCallerField"; first["func"] != want {
+		t.Errorf("Expected func %q, got %v", want, first["func"])
+	}
+	if c, _ := second["caller"].(string); !strings.HasSuffix(c, fmt.Sprintf("/formatter_test.go:%d", line+8)) {
+		t.Errorf("Expected the package function caller to be this file, got %q", c)
+	}
+	if _, ok := second["func"]; ok {
+		t.Error("Unexpected func without CallerFunc")
+	}
+}
+
+func TestNumericLevel(t *testing.T) {
+	lg := Get("numeric")
+	lg.SetFormatter(&JSONFormatter{})
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	lg.Info("not numbered")
+	if bytes.Contains(buf.Bytes(), []byte("level_num")) {
+		t.Errorf("level_num should be opt-in: %q", buf.String())
+	}
+
+	lg.SetEmitNumericLevel(true)
+	for f, num := range map[SeverityFilter]int{
+		EmergSeverity: 0, AlertSeverity: 1, CritSeverity: 2, ErrSeverity: 3,
+		WarningSeverity: 4, NoticeSeverity: 5, InfoSeverity: 6, DebugSeverity: 7,
+	} {
+		buf.Reset()
+		lg.doPrintf(f, "numbered")
+		var line struct {
+			Level    string `json:"level"`
+			LevelNum *int   `json:"level_num"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line.LevelNum == nil || *line.LevelNum != num {
+			t.Errorf("Expected level_num %d for %s, got %q", num, line.Level, buf.String())
+		}
+	}
+}
+
+func TestChainFormatter(t *testing.T) {
+	hash := func(e *Entry) {
+		e.Fields["msg_hash"] = fmt.Sprintf("%x", sha1.Sum([]byte(e.Message)))
+	}
+	upper := func(e *Entry) {
+		e.Message = strings.ToUpper(e.Message)
+	}
+
+	lg := Get("chain")
+	lg.SetFormatter(ChainFormatter(ChainFormatter(&JSONFormatter{}, hash), upper))
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	fields := Fields{"user": "alex"}
+	lg.logFields(InfoSeverity, fields, "hello")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x", sha1.Sum([]byte("HELLO"))); line["msg_hash"] != want {
+		t.Errorf("Expected msg_hash %s, got %q", want, buf.String())
+	}
+	if line["msg"] != "HELLO" || line["user"] != "alex" {
+		t.Errorf("Unexpected line %q", buf.String())
+	}
+	if _, ok := fields["msg_hash"]; ok {
+		t.Error("Decorators must not change the caller's fields")
+	}
+}
+
+func TestLazyField(t *testing.T) {
+	lg := GetWithFlags("lazy", 0)
+	lg.SetFilter(InfoSeverity)
+	defer lg.SetFilter(AllSeverity)
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	calls := 0
+	f := Fields{"stats": Lazy(func() interface{} {
+		calls++
+		return "expensive"
+	})}
+
+	lg.logFields(DebugSeverity, f, "filtered out")
+	if calls != 0 {
+		t.Errorf("Lazy field evaluated for a filtered out line")
+	}
+
+	lg.logFields(InfoSeverity, f, "written")
+	if calls != 1 {
+		t.Errorf("Expected 1 evaluation, got %d", calls)
+	}
+	if want := "lazy  info     written stats=expensive\n"; buf.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+	}
+}
+
+func TestRouteFormat(t *testing.T) {
+	SetClock(fixedClock)
+	defer SetClock(nil)
+
+	path := filepath.Join(t.TempDir(), "mixed.log")
+	lg := GetWithFlags("mixed", 0)
+	fh, err := lg.AddFileHandler(path, 0, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	lg.RouteFormat(ErrSeverity|CritSeverity, fh, &JSONFormatter{})
+
+	lg.Info("started")
+	lg.Err("failed")
+	lg.Info("retrying")
+
+	b, _ := os.ReadFile(path)
+	want := "mixed  info     started\n" +
+		`{"time":"2013-06-21T08:21:44.680513+02:00","level":"err","logger":"mixed","msg":"failed"}` + "\n" +
+		"mixed  info     retrying\n"
+	if string(b) != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", b, want)
+	}
+}
+
+func TestWithLevelFields(t *testing.T) {
+	lg := GetWithFlags("level_fields", 0)
+	calls := 0
+	lg.SetFormatter(ChainFormatter(&TextFormatter{}, WithLevelFields(ErrSeverity, Fields{"dump": Lazy(func() interface{} {
+		calls++
+		return "GET /"
+	})})))
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	lg.Info("served")
+	if calls != 0 {
+		t.Error("Fields evaluated for an info line")
+	}
+	lg.Err("failed")
+	lg.Crit("crashed")
+
+	want := "level_fields  info     served\n" +
+		"level_fields  err      failed dump=\"GET /\"\n" +
+		"level_fields  crit     crashed dump=\"GET /\"\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+	}
+}
+
+func TestTypedFields(t *testing.T) {
+	for _, tc := range []struct {
+		v          interface{}
+		text, json string
+	}{
+		{true, "true", "true"},
+		{int64(-42), "-42", "-42"},
+		{uint64(1 << 63), "9223372036854775808", "9223372036854775808"},
+		{42, "42", "42"},
+		{1.5, "1.5", "1.5"},
+		{float32(0.1), "0.1", "0.1"},
+		{1e21, "1e+21", "1e+21"},
+		{1e-7, "1e-07", "1e-7"},
+		{math.NaN(), "NaN", `"NaN"`},
+		{math.Inf(1), "+Inf", `"+Inf"`},
+		{math.Inf(-1), "-Inf", `"-Inf"`},
+	} {
+		if s := fieldValue(tc.v); s != tc.text || s != fmt.Sprint(tc.v) {
+			t.Errorf("Text %v: expected %q, got %q", tc.v, tc.text, s)
+		}
+
+		var buf bytes.Buffer
+		writeJSONField(&buf, "v", tc.v, true)
+		if want := `"v":` + tc.json; buf.String() != want {
+			t.Errorf("JSON %v: expected %q, got %q", tc.v, want, buf.String())
+		}
+		if !math.IsNaN(toFloat(tc.v)) && !math.IsInf(toFloat(tc.v), 0) {
+			if b, _ := json.Marshal(tc.v); string(b) != tc.json {
+				t.Errorf("JSON %v differs from encoding/json %s", tc.v, b)
+			}
+		}
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	if f, ok := v.(float64); ok {
+		return f
+	}
+	return 0
+}
+
+var benchValues = []interface{}{int64(12345), true, 0.75, uint64(7)}
+
+func BenchmarkFieldValueTyped(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, v := range benchValues {
+			_ = fieldValue(v)
+		}
+	}
+}
+
+func BenchmarkFieldValueSprint(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, v := range benchValues {
+			_ = fmt.Sprintf("%v", v)
+		}
+	}
+}
+
+func BenchmarkJSONTypedFields(b *testing.B) {
+	e := &Entry{Time: fixedClock(), Level: InfoSeverity, Message: "m", Fields: Fields{"n": int64(12345), "ok": true, "ratio": 0.75}}
+	jf := &JSONFormatter{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		jf.Format(e)
+	}
+}
+
+func TestLevelColumnCombined(t *testing.T) {
+	for s, want := range map[SeverityFilter]string{
+		EmergSeverity:                 " emerg   ",
+		InfoSeverity:                  " info    ",
+		WarningSeverity:               " warning ",
+		InfoSeverity | DebugSeverity:  " SeverityFilter(192) ",
+		ErrSeverity | WarningSeverity: " SeverityFilter(24) ",
+	} {
+		var buf bytes.Buffer
+		writeLevelColumn(&buf, s)
+		if buf.String() != want {
+			t.Errorf("Expected %q, got %q", want, buf.String())
+		}
+	}
+}
+
+func BenchmarkTextFormat(b *testing.B) {
+	e := &Entry{Time: fixedClock(), Level: InfoSeverity, Flags: log.LstdFlags, Message: "request served"}
+	tf := &TextFormatter{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tf.Format(e)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncHandler queues log lines and writes them to another handler from a
+// background goroutine so logging does not wait for slow destinations.
+//
+// When the queue is full Write either blocks until there is room or, if the
+// handler drops on full, discards the line and counts it. Errors from the
+// wrapped handler are not returned to the writer.
+type AsyncHandler struct {
+	inner   Handler
+	queue   chan asyncLine
+	drop    bool
+	dropped uint64
+	closed  bool
+	done    chan struct{}
+	mutex   sync.RWMutex
+}
+
+// asyncLine is a queued line or, if flushed is set, a flush request.
+type asyncLine struct {
+	b       []byte
+	flushed chan struct{}
+}
+
+// NewAsyncHandler returns a handler that writes to inner in the background with room for bufSize queued lines.
+func NewAsyncHandler(inner Handler, bufSize int, dropOnFull bool) *AsyncHandler {
+	ah := &AsyncHandler{inner: inner, queue: make(chan asyncLine, bufSize), drop: dropOnFull, done: make(chan struct{})}
+	go ah.run()
+	return ah
+}
+
+// Write queues a log message.
+func (ah *AsyncHandler) Write(b []byte) (n int, err error) {
+	ah.mutex.RLock()
+	defer ah.mutex.RUnlock()
+
+	if ah.closed {
+		return 0, handlerError(ah, "", ErrHandlerClosed)
+	}
+
+	// copy, the caller may reuse b
+	line := asyncLine{b: append([]byte(nil), b...)}
+	if ah.drop {
+		select {
+		case ah.queue <- line:
+		default:
+			atomic.AddUint64(&ah.dropped, 1)
+		}
+	} else {
+		ah.queue <- line
+	}
+	return len(b), nil
+}
+
+// Flush waits until the lines queued so far are written and flushes the wrapped handler.
+func (ah *AsyncHandler) Flush() error {
+	ah.mutex.RLock()
+	if ah.closed {
+		ah.mutex.RUnlock()
+		return handlerError(ah, "", ErrHandlerClosed)
+	}
+	flushed := make(chan struct{})
+	ah.queue <- asyncLine{flushed: flushed}
+	ah.mutex.RUnlock()
+
+	<-flushed
+	return Flush(ah.inner)
+}
+
+// Close writes the queued lines and closes the wrapped handler.
+func (ah *AsyncHandler) Close() error {
+	ah.mutex.Lock()
+	if ah.closed {
+		ah.mutex.Unlock()
+		return nil
+	}
+	ah.closed = true
+	close(ah.queue)
+	ah.mutex.Unlock()
+
+	<-ah.done
+	return ah.inner.Close()
+}
+
+// String returns the handler name.
+func (ah *AsyncHandler) String() string {
+	return "AsyncHandler"
+}
+
+// Describe returns the description of the wrapped handler.
+func (ah *AsyncHandler) Describe() string {
+	return "async " + Describe(ah.inner)
+}
+
+// Dropped returns the number of lines discarded because the queue was full.
+func (ah *AsyncHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&ah.dropped)
+}
+
+func (ah *AsyncHandler) run() {
+	defer close(ah.done)
+	for line := range ah.queue {
+		if line.flushed != nil {
+			close(line.flushed)
+			continue
+		}
+		ah.inner.Write(line.b)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"log/syslog"
+	"os"
+)
+
+// ANSI color codes per syslog severity.
+var severityColors = map[syslog.Priority]string{
+	syslog.LOG_EMERG:   "\x1b[1;31m", // bold red
+	syslog.LOG_ALERT:   "\x1b[1;31m",
+	syslog.LOG_CRIT:    "\x1b[1;31m",
+	syslog.LOG_ERR:     "\x1b[31m", // red
+	syslog.LOG_WARNING: "\x1b[33m", // yellow
+	syslog.LOG_NOTICE:  "\x1b[36m", // cyan
+	syslog.LOG_INFO:    "\x1b[32m", // green
+	syslog.LOG_DEBUG:   "\x1b[90m", // gray
+}
+
+// severityNames are the severity keywords looked up in a line to color.
+var severityNames = map[syslog.Priority]string{
+	syslog.LOG_EMERG:   "emerg",
+	syslog.LOG_ALERT:   "alert",
+	syslog.LOG_CRIT:    "crit",
+	syslog.LOG_ERR:     "err",
+	syslog.LOG_WARNING: "warning",
+	syslog.LOG_NOTICE:  "notice",
+	syslog.LOG_INFO:    "info",
+	syslog.LOG_DEBUG:   "debug",
+}
+
+const colorReset = "\x1b[0m"
+
+// ColorStdoutHandler writes to os.Stdout with the severity keyword of each line colored,
+// e.g. red for err and yellow for warning. Lines without a keyword are colored as a whole.
+// Color is only used if stdout is a terminal and the NO_COLOR environment variable is not
+// set, unless forced with the ForceColor option.
+type ColorStdoutHandler struct {
+	color bool
+}
+
+// ColorOption configures a ColorStdoutHandler.
+type ColorOption func(ch *ColorStdoutHandler)
+
+// ForceColor turns color on or off regardless of the terminal and NO_COLOR.
+func ForceColor(on bool) ColorOption {
+	return func(ch *ColorStdoutHandler) {
+		ch.color = on
+	}
+}
+
+// NewColorStdoutHandler returns a handler for os.Stdout that colors the severities.
+// An error is returned if os.Stdout is closed or otherwise unusable.
+func NewColorStdoutHandler(opts ...ColorOption) (*ColorStdoutHandler, error) {
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to use stdout: %v", err)
+	}
+	_, noColor := os.LookupEnv("NO_COLOR")
+	ch := &ColorStdoutHandler{color: fi.Mode()&os.ModeCharDevice != 0 && !noColor}
+	for _, opt := range opts {
+		opt(ch)
+	}
+	return ch, nil
+}
+
+// Write a log message without color, the severity is unknown.
+func (ch *ColorStdoutHandler) Write(b []byte) (n int, err error) {
+	return ch.write(b, len(b))
+}
+
+// WriteSeverity writes a log message colored by its syslog severity.
+func (ch *ColorStdoutHandler) WriteSeverity(severity syslog.Priority, b []byte) (n int, err error) {
+	code, ok := severityColors[severity&0x07]
+	if !ch.color || !ok {
+		return ch.write(b, len(b))
+	}
+	return ch.write(colorize(b, severityNames[severity&0x07], code), len(b))
+}
+
+// write writes the possibly colored b and returns n, the length of the uncolored message.
+func (ch *ColorStdoutHandler) write(b []byte, n int) (int, error) {
+	written, err := os.Stdout.Write(b)
+	if err == nil && written < len(b) {
+		err = ErrShortWrite
+	}
+	if err != nil {
+		return 0, handlerError(ch, "", err)
+	}
+	return n, nil
+}
+
+// Close handler.
+func (ch *ColorStdoutHandler) Close() error {
+	return nil
+}
+
+// String returns the handler name.
+func (ch *ColorStdoutHandler) String() string {
+	return "ColorStdoutHandler"
+}
+
+// Describe returns the destination.
+func (ch *ColorStdoutHandler) Describe() string {
+	if ch.color {
+		return "stdout (color)"
+	}
+	return "stdout"
+}
+
+// colorize wraps the first name keyword in b, or else the whole line, in the color code.
+func colorize(b []byte, name, code string) []byte {
+	line := bytes.TrimSuffix(b, []byte("\n"))
+	var buf bytes.Buffer
+	buf.Grow(len(b) + len(code) + len(colorReset))
+	if i := bytes.Index(line, []byte(" "+name+" ")); i >= 0 {
+		buf.Write(line[:i+1])
+		buf.WriteString(code)
+		buf.WriteString(name)
+		buf.WriteString(colorReset)
+		buf.Write(line[i+1+len(name):])
+	} else {
+		buf.WriteString(code)
+		buf.Write(line)
+		buf.WriteString(colorReset)
+	}
+	buf.Write(b[len(line):])
+	return buf.Bytes()
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"testing"
+)
+
+func TestColorStdoutHandler(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	// a pipe is not a terminal
+	plain, err := NewColorStdoutHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := NewColorStdoutHandler(ForceColor(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain.WriteSeverity(syslog.LOG_ERR, []byte("main  err      disk full\n"))
+	n, err := ch.WriteSeverity(syslog.LOG_ERR, []byte("main  err      disk full\n"))
+	if err != nil || n != 25 {
+		t.Errorf("Expected the uncolored length 25, got %d: %v", n, err)
+	}
+	ch.WriteSeverity(syslog.LOG_WARNING, []byte(`{"level":"warning","msg":"slow"}`+"\n"))
+	ch.Write([]byte("main  info     no severity\n"))
+	w.Close()
+	os.Stdout = stdout
+
+	b, _ := ioutil.ReadAll(r)
+	want := "main  err      disk full\n" +
+		"main  \x1b[31merr\x1b[0m      disk full\n" +
+		"\x1b[33m{\"level\":\"warning\",\"msg\":\"slow\"}\x1b[0m\n" +
+		"main  info     no severity\n"
+	if string(b) != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", b, want)
+	}
+}
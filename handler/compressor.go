@@ -0,0 +1,90 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Compressor compresses rotated log files.
+type Compressor interface {
+	// Compress writes the compressed content of the file src to the file dst.
+	Compress(src, dst string) error
+	// Extension returns the filename extension of compressed files, e.g. ".gz".
+	Extension() string
+}
+
+// GzipCompressor is the default Compressor.
+type GzipCompressor struct {
+	Level int // gzip compression level, 0 for gzip.DefaultCompression
+}
+
+// Compress gzips src to dst.
+func (gc GzipCompressor) Compress(src, dst string) error {
+	level := gc.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzipFile(src, dst, level)
+}
+
+// Extension returns ".gz".
+func (gc GzipCompressor) Extension() string {
+	return ".gz"
+}
+
+// gzipLevel compresses with gzip at the level, gzip.NoCompression included.
+// It is used by a FileHandler without a Compressor, see SetCompressLevel.
+type gzipLevel int
+
+func (gl gzipLevel) Compress(src, dst string) error {
+	return gzipFile(src, dst, int(gl))
+}
+
+func (gl gzipLevel) Extension() string {
+	return ".gz"
+}
+
+// gzipFile gzips src to dst at the compression level.
+func gzipFile(src, dst string, level int) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	zw, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if _, err = io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// compress compresses filePath with c and removes the original on success.
+func compress(c Compressor, filePath string) error {
+	dst := filePath + c.Extension()
+	if err := c.Compress(filePath, dst); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("Unable to compress %s: %v", filePath, err)
+	}
+	return os.Remove(filePath)
+}
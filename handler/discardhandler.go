@@ -0,0 +1,48 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"sync/atomic"
+)
+
+// DiscardHandler discards the log lines but counts them, e.g. to measure the
+// logging overhead in benchmarks without the cost of any I/O.
+type DiscardHandler struct {
+	writes uint64
+	bytes  uint64
+}
+
+// NewDiscardHandler returns a handler that discards everything written to it.
+func NewDiscardHandler() *DiscardHandler {
+	return &DiscardHandler{}
+}
+
+// Write counts and discards a log message.
+func (dh *DiscardHandler) Write(b []byte) (n int, err error) {
+	atomic.AddUint64(&dh.writes, 1)
+	atomic.AddUint64(&dh.bytes, uint64(len(b)))
+	return len(b), nil
+}
+
+// Close handler.
+func (dh *DiscardHandler) Close() error {
+	return nil
+}
+
+// String returns the handler name.
+func (dh *DiscardHandler) String() string {
+	return "DiscardHandler"
+}
+
+// Writes returns the number of writes discarded.
+func (dh *DiscardHandler) Writes() uint64 {
+	return atomic.LoadUint64(&dh.writes)
+}
+
+// Bytes returns the number of bytes discarded.
+func (dh *DiscardHandler) Bytes() uint64 {
+	return atomic.LoadUint64(&dh.bytes)
+}
@@ -5,59 +5,262 @@
 package handler
 
 import (
-	"errors"
+	"bufio"
+	"compress/gzip"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 // FileHandler writes to file.
 type FileHandler struct {
 	filePath string
-	written  uint // bytes written
-	rotate   byte // how many log files to rotate between
-	size     uint // rotate at file size
-	seq      byte // next rotated log filename sequence
-	compress bool // compress rotated logs
-	daily    bool // rotate daily
+	written  uint          // bytes written
+	rotated  uint64        // number of rotations
+	rotate   byte          // how many log files to rotate between
+	size     uint          // rotate at file size
+	seq      byte          // next rotated log filename sequence
+	compress bool          // compress rotated logs
+	level    int           // gzip compression level
+	zipper   Compressor    // compresses rotated logs, gzip if nil
+	daily    bool          // rotate daily
+	interval time.Duration // rotate at each interval boundary
+	rotateC  chan struct{} // closed to stop the running rotation timer, nil if none
+	pattern  string        // time layout for rotated log filenames, "" for sequence numbers
+	minFree  uint64        // prune rotated logs when less disk space is available
+	marker   string        // last line of a cleanly closed or rotated log file
+	shared   bool          // the log file is written by other processes too
+	utc      bool          // rotate and name rotated logs by UTC instead of local time
+	buf      *bufio.Writer // buffers writes to out, nil if unbuffered
+	flushIn  time.Duration // flush the buffer at this interval, 0 never
+	flushC   chan struct{} // closed to stop the running flush timer, nil if none
+	reopenIn time.Duration // check for an externally rotated log file at most this often, 0 never
+	checked  time.Time     // last check for an externally rotated log file
+	free     func(dir string) (uint64, error)
+	writeOut func(f *os.File, b []byte) (int, error) // writes to out, (*os.File).Write if nil
 	out      *os.File
+	clock    Clock
+	onError  func(error)    // receives errors from background rotation and compression
+	errMutex sync.Mutex     // guards onError
+	zipping  sync.WaitGroup // running compressions
+	timers   sync.WaitGroup // running rotation and flush timers
 	mutex    sync.Mutex
 }
 
+// Clock is the time source used by a FileHandler for time based rotation.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
 // Write log message to file and rotate the file if necessary.
+// The write and any rotation it triggers happen under the same lock as
+// daily rotation so a line is never written to a file being rotated away.
 func (fh *FileHandler) Write(b []byte) (n int, err error) {
-	n, err = fh.out.Write(b)
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if fh.out == nil {
+		return 0, handlerError(fh, fh.filePath, ErrHandlerClosed)
+	}
+	if fh.reopenIn > 0 {
+		if now := fh.clock.Now(); now.Sub(fh.checked) >= fh.reopenIn {
+			fh.checked = now
+			if err = fh.checkExternalRotation(); err != nil {
+				return 0, handlerError(fh, fh.filePath, err)
+			}
+		}
+	}
+
+	if fh.shared {
+		// a single write so that the line is not interleaved with the lines of other processes
+		n, err = writeOnce(fh.out, b)
+	} else if fh.buf != nil {
+		n, err = fh.buf.Write(b)
+	} else {
+		n, err = fh.writeAll(b)
+	}
+	// count what was written even on error so that the size matches the file
+	fh.written += uint(n)
+	if err == nil && n < len(b) {
+		err = ErrShortWrite
+	}
 	if err != nil {
-		return n, err
+		return n, handlerError(fh, fh.filePath, err)
 	}
 
-	if n < len(b) {
-		return n, errors.New("Unable to write all bytes to " + fh.filePath)
+	if fh.shared {
+		// the file may have grown or been rotated by another process
+		if err = fh.statShared(); err != nil {
+			return n, handlerError(fh, fh.filePath, err)
+		}
 	}
+	if !fh.daily && fh.rotate > 0 && fh.size > 0 && fh.written >= fh.size {
+		if err := fh.rotateOrReopen(); err != nil {
+			return n, handlerError(fh, fh.filePath, err)
+		}
+	}
+	return n, nil
+}
 
+// SetBuffered sets a write buffer of size bytes so that lines are written to the log file
+// in larger chunks instead of one write system call each. The buffer is written out when
+// full, every flush interval unless flush is 0, before rotation, on Flush and on Close.
+// Lines still in the buffer are lost if the process crashes, at most size bytes or the
+// lines of one flush interval. A size of 0 removes the buffer. A shared file, see
+// SetSharedFile, is not buffered.
+func (fh *FileHandler) SetBuffered(size int, flush time.Duration) error {
 	fh.mutex.Lock()
 	defer fh.mutex.Unlock()
 
-	fh.written += uint(n)
-	if !fh.daily && fh.rotate > 0 && fh.size > 0 && fh.written >= fh.size {
-		f, err := fh.rotateLog()
-		if err != nil {
-			return n, err
-		}
-		fh.written = 0
-		fh.out = f
+	if err := fh.flushBuffer(); err != nil {
+		return handlerError(fh, fh.filePath, err)
 	}
-	return n, err
+	fh.buf = nil
+	if size > 0 {
+		fh.buf = bufio.NewWriterSize(fh.out, size)
+	}
+	fh.flushIn = flush
+	stopTimer(&fh.flushC)
+	if fh.buf != nil && flush > 0 {
+		fh.flushC = make(chan struct{})
+		fh.timers.Add(1)
+		go fh.flushTimed(fh.flushC)
+	}
+	return nil
+}
+
+// SetSharedFile sets whether the log file is shared with other processes, e.g. several
+// workers logging to the same file. Each line is then written with a single write system
+// call so lines from different processes don't interleave, the size for rotation is taken
+// from the file instead of counted, and a file rotated by another process is reopened
+// instead of rotated again. Rotation is serialized between the processes by a file lock.
+func (fh *FileHandler) SetSharedFile(shared bool) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	fh.shared = shared
 }
 
-// Close handler
+// SetReopenInterval sets how often Write checks whether the log file has been rotated by
+// another program, e.g. logrotate, and reopens the log file if it was moved away. A log
+// file truncated in place, as by copytruncate, is detected too. The check costs a stat
+// system call and is done at most once per interval. 0 disables the check, the default.
+func (fh *FileHandler) SetReopenInterval(d time.Duration) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	fh.reopenIn = d
+}
+
+// SetUTC sets whether daily rotation happens at midnight UTC instead of local midnight,
+// and rotated logs named with SetNamePattern get the UTC time.
+func (fh *FileHandler) SetUTC(utc bool) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if fh.utc != utc {
+		fh.utc = utc
+		fh.restartTimer()
+	}
+}
+
+// Close handler. The rotation and flush timers are stopped, and writing, flushing or
+// rotating afterwards returns ErrHandlerClosed.
 func (fh *FileHandler) Close() error {
-	if fh.out != nil {
-		return fh.Close()
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	stopTimer(&fh.flushC)
+	stopTimer(&fh.rotateC)
+	if fh.out == nil {
+		return nil
 	}
-	return nil
+	out := fh.out
+	fh.out = nil
+	if err := fh.flushBuffer(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := writeCloseMarker(out, fh.marker); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Flush commits the log file to disk.
+func (fh *FileHandler) Flush() error {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if fh.out == nil {
+		return handlerError(fh, fh.filePath, ErrHandlerClosed)
+	}
+	if err := fh.flushBuffer(); err != nil {
+		return handlerError(fh, fh.filePath, err)
+	}
+	return handlerError(fh, fh.filePath, fh.out.Sync())
+}
+
+// RotateNow rotates the log file immediately, e.g. on SIGHUP. With no rotated
+// files configured the log file is only reopened.
+func (fh *FileHandler) RotateNow() error {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if fh.out == nil {
+		return handlerError(fh, fh.filePath, ErrHandlerClosed)
+	}
+	return fh.rotateOrReopen()
+}
+
+// Verify checks that the log file can be opened for writing, without writing to it,
+// so lines appended by other processes to a shared file are left alone.
+func (fh *FileHandler) Verify() error {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if fh.out == nil {
+		return handlerError(fh, fh.filePath, ErrHandlerClosed)
+	}
+	f, err := os.OpenFile(fh.filePath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return handlerError(fh, fh.filePath, err)
+	}
+	return f.Close()
+}
+
+// Written returns the number of bytes written to the current log file.
+func (fh *FileHandler) Written() uint {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	return fh.written
+}
+
+// RotationCount returns how many times the log file has been rotated,
+// including a rotation of an existing log file when the handler was created.
+func (fh *FileHandler) RotationCount() uint64 {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	return fh.rotated
 }
 
 // Rotate returns how many log files to rotate between.
@@ -99,17 +302,104 @@ func (fh *FileHandler) SetCompress(compress bool) {
 	fh.compress = compress
 }
 
+// CompressLevel returns the gzip compression level of rotated log files.
+func (fh *FileHandler) CompressLevel() int {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	return fh.level
+}
+
+// SetCompressLevel sets the gzip compression level of rotated log files, from gzip.HuffmanOnly
+// to gzip.BestCompression, e.g. gzip.BestSpeed on hosts short of CPU. The default is
+// gzip.DefaultCompression.
+func (fh *FileHandler) SetCompressLevel(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("Invalid compression level %d", level)
+	}
+
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	fh.level = level
+	return nil
+}
+
+// SetCompressor sets the compressor of rotated log files, e.g. for zstd. A nil compressor
+// restores gzip with the level set by SetCompressLevel. An error is returned for a
+// compressor with an empty extension, whose files would replace the rotated logs.
+func (fh *FileHandler) SetCompressor(c Compressor) error {
+	if c != nil && c.Extension() == "" {
+		return fmt.Errorf("Invalid compressor %T: empty extension", c)
+	}
+
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	fh.zipper = c
+	return nil
+}
+
+// compressor returns the compressor of rotated log files. The caller must hold the lock.
+func (fh *FileHandler) compressor() Compressor {
+	if fh.zipper == nil {
+		return gzipLevel(fh.level)
+	}
+	return fh.zipper
+}
+
 // Seq returns the next log file sequence number for the rotated log file.
 func (fh *FileHandler) Seq() byte {
 	return fh.seq
 }
 
-// SetSeq sets the log file sequence number for the next rotated log file.
-func (fh *FileHandler) SetSeq(seq byte) {
+// SetSeq sets the log file sequence number for the next rotated log file, from 1 to the
+// number of rotated log files. An error is returned for a sequence number out of that
+// range, e.g. with rotation disabled, and the sequence number is left unchanged.
+func (fh *FileHandler) SetSeq(seq byte) error {
 	fh.mutex.Lock()
 	defer fh.mutex.Unlock()
 
+	if seq < 1 || seq > fh.rotate {
+		return fmt.Errorf("Invalid sequence no %d, must be from 1 to %d", seq, fh.rotate)
+	}
 	fh.seq = seq
+	return nil
+}
+
+// SetNamePattern names rotated log files with the rotation time formatted with layout,
+// e.g. "2006-01-02T15-04-05" gives app.log.2024-01-02T15-04-05, instead of a sequence number.
+// If the name is taken a counter is appended, e.g. app.log.2024-01-02T15-04-05.1.
+// Timestamped files are never reused so their number is not limited by the rotation count.
+// An empty layout restores sequence numbers.
+func (fh *FileHandler) SetNamePattern(layout string) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	fh.pattern = layout
+}
+
+// SetCloseMarker sets a line written as the last line of the log file when the handler
+// is closed or the file is rotated, so that consumers can tell a complete file from one
+// cut short by a crash. An empty marker, the default, writes nothing.
+func (fh *FileHandler) SetCloseMarker(marker string) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if marker != "" && !strings.HasSuffix(marker, "\n") {
+		marker += "\n"
+	}
+	fh.marker = marker
+}
+
+// SetDiskPressureCheck makes the handler check the available disk space before each rotation.
+// If less than minFreeBytes is available the oldest rotated log files are removed, beyond the
+// rotation count, until enough space is free or none are left. 0 disables the check.
+func (fh *FileHandler) SetDiskPressureCheck(minFreeBytes uint64) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	fh.minFree = minFreeBytes
 }
 
 // Daily returns whether the log file rotates daily.
@@ -122,10 +412,56 @@ func (fh *FileHandler) SetDaily(daily bool) {
 	fh.mutex.Lock()
 	defer fh.mutex.Unlock()
 
-	if !fh.daily && daily {
-		go fh.rotateDaily()
+	if fh.daily != daily {
+		fh.daily = daily
+		fh.restartTimer()
+	}
+}
+
+// RotateInterval returns the interval of time based rotation.
+func (fh *FileHandler) RotateInterval() time.Duration {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	return fh.interval
+}
+
+// SetRotateInterval rotates the log file every d, at multiples of d since the zero time,
+// e.g. at each full hour for time.Hour. Size based rotation still applies in between.
+// Daily rotation, the same as a 24h interval aligned to local midnight, takes precedence.
+// 0 disables interval rotation.
+func (fh *FileHandler) SetRotateInterval(d time.Duration) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if d < 0 {
+		d = 0
+	}
+	if fh.interval != d {
+		fh.interval = d
+		fh.restartTimer()
+	}
+}
+
+// SetClock sets the time source used for daily rotation. It is meant for tests,
+// the real clock is used by default. Set it before enabling daily rotation.
+func (fh *FileHandler) SetClock(c Clock) {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	if c == nil {
+		c = realClock{}
 	}
-	fh.daily = daily
+	fh.clock = c
+}
+
+// SetErrorHandler sets a function that receives the errors from daily rotation and
+// compression, which run in the background. Without one the errors are written to os.Stderr.
+func (fh *FileHandler) SetErrorHandler(fn func(error)) {
+	fh.errMutex.Lock()
+	defer fh.errMutex.Unlock()
+
+	fh.onError = fn
 }
 
 // String returns the handler name.
@@ -133,6 +469,32 @@ func (fh *FileHandler) String() string {
 	return "FileHandler"
 }
 
+// Describe returns the log file path and rotation settings.
+func (fh *FileHandler) Describe() string {
+	fh.mutex.Lock()
+	defer fh.mutex.Unlock()
+
+	var opts []string
+	if fh.size > 0 && fh.rotate > 0 {
+		opts = append(opts, "max "+formatSize(fh.size))
+	}
+	if fh.rotate > 0 {
+		opts = append(opts, fmt.Sprintf("%d rotations", fh.rotate))
+	}
+	if fh.daily {
+		opts = append(opts, "daily")
+	} else if fh.interval > 0 {
+		opts = append(opts, "every "+fh.interval.String())
+	}
+	if fh.compress {
+		opts = append(opts, "gzip")
+	}
+	if len(opts) == 0 {
+		return "file " + fh.filePath
+	}
+	return "file " + fh.filePath + " (" + strings.Join(opts, ", ") + ")"
+}
+
 // DefRotatation and DefFileSize sets the default number of rotated files and the max size per log file.
 const (
 	DefRotatation = 5
@@ -145,9 +507,34 @@ func NewStdFileHandler(filePath string) (*FileHandler, error) {
 	return NewFileHandler(filePath, DefFileSize, DefRotatation, defStartSeq, false, false)
 }
 
-// NewFileHandler returns a new file handler with file rotation enabled
+// NewFileHandler returns a new file handler with file rotation enabled. The log file is
+// rotated, keeping maxRotation rotated logs, when it reaches maxFileSize or daily at
+// midnight if daily is set, in which case the size is not checked. A maxRotation of 0
+// disables rotation. See NewIntervalFileHandler for rotation at an interval.
+//
+// An error is returned for an empty filePath, a startSeq above maxRotation, for a
+// maximum size, compression or daily rotation with maxRotation 0, which would be ignored,
+// and for rotation with maxFileSize 0 and daily not set, which would never happen.
 func NewFileHandler(filePath string, maxFileSize uint, maxRotation byte, startSeq byte, compress bool, daily bool) (*FileHandler, error) {
-	fh := &FileHandler{filePath: filePath, size: maxFileSize, rotate: maxRotation, seq: startSeq, compress: compress, daily: daily}
+	return newFileHandler(filePath, maxFileSize, maxRotation, startSeq, compress, daily, 0)
+}
+
+// NewIntervalFileHandler returns a new file handler like NewFileHandler that rotates the
+// log file at each interval boundary, e.g. every hour, see SetRotateInterval, and also when
+// it reaches maxFileSize unless that is 0. An error is returned for an interval of 0 or
+// less, and as by NewFileHandler.
+func NewIntervalFileHandler(filePath string, maxFileSize uint, maxRotation byte, startSeq byte, compress bool, interval time.Duration) (*FileHandler, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid file handler %q: rotation interval %v", filePath, interval)
+	}
+	return newFileHandler(filePath, maxFileSize, maxRotation, startSeq, compress, false, interval)
+}
+
+func newFileHandler(filePath string, maxFileSize uint, maxRotation byte, startSeq byte, compress bool, daily bool, interval time.Duration) (*FileHandler, error) {
+	if err := validateFileHandler(filePath, maxFileSize, maxRotation, startSeq, compress, daily, interval); err != nil {
+		return nil, err
+	}
+	fh := &FileHandler{filePath: filePath, size: maxFileSize, rotate: maxRotation, seq: startSeq, compress: compress, level: gzip.DefaultCompression, daily: daily, interval: interval, clock: realClock{}}
 	// find a free log file sequence no
 	fh.findSequence()
 	f, err := fh.rotateLog()
@@ -156,28 +543,74 @@ func NewFileHandler(filePath string, maxFileSize uint, maxRotation byte, startSe
 	}
 
 	fh.out = f
-	if fh.daily {
-		go fh.rotateDaily()
-	}
+	fh.restartTimer()
 	return fh, nil
 }
 
+func validateFileHandler(filePath string, maxFileSize uint, maxRotation byte, startSeq byte, compress bool, daily bool, interval time.Duration) error {
+	var invalid string
+	switch {
+	case filePath == "":
+		invalid = "no file path"
+	case maxRotation > 0 && startSeq > maxRotation:
+		invalid = fmt.Sprintf("start sequence no %d is above the %d rotations", startSeq, maxRotation)
+	case maxRotation == 0 && maxFileSize > 0:
+		invalid = fmt.Sprintf("max size %d with rotation disabled", maxFileSize)
+	case maxRotation == 0 && compress:
+		invalid = "compression with rotation disabled"
+	case maxRotation == 0 && daily:
+		invalid = "daily rotation with rotation disabled"
+	case maxRotation == 0 && interval > 0:
+		invalid = "interval rotation with rotation disabled"
+	case maxRotation > 0 && maxFileSize == 0 && !daily && interval == 0:
+		invalid = "rotation with no max size, daily or interval rotation"
+	default:
+		return nil
+	}
+	return fmt.Errorf("Invalid file handler %q: %s", filePath, invalid)
+}
+
+// findSequence selects the sequence no for the next rotated log file: the first free one
+// from the start sequence no or, if all rotated log files exist, the one of the oldest file
+// so that rotation stays within the limit after a restart.
 func (fh *FileHandler) findSequence() {
-	// Find a free rotated log file sequence no
-	fileName := "%v.%d"
-	if (fh.compress) {
-		fileName = "%v.%d.gz"
+	if fh.rotate == 0 {
+		return
+	}
+	if fh.seq == 0 || fh.seq > fh.rotate {
+		fh.seq = 1
 	}
 
-	rotateFile := fmt.Sprintf(fileName, fh.filePath, fh.seq)
-	for {
-		if _, err := os.Stat(rotateFile); os.IsNotExist(err) {
+	var oldest time.Time
+	oldestSeq := fh.seq
+	for i := 0; i < int(fh.rotate); i++ {
+		seq := byte((int(fh.seq)-1+i)%int(fh.rotate) + 1)
+		mtime, ok := fh.rotatedModTime(seq)
+		if !ok {
 			// found seq no, file does not exist
-			break
+			fh.seq = seq
+			return
+		}
+		if i == 0 || mtime.Before(oldest) {
+			oldest, oldestSeq = mtime, seq
+		}
+	}
+	fh.seq = oldestSeq
+}
+
+// rotatedModTime returns the modification time of the rotated log file with the sequence no,
+// compressed or not, and false if there is none.
+func (fh *FileHandler) rotatedModTime(seq byte) (mtime time.Time, ok bool) {
+	name := fmt.Sprintf("%v.%d", fh.filePath, seq)
+	for _, n := range []string{name, name + fh.compressor().Extension()} {
+		if fi, err := os.Stat(n); err == nil {
+			if !ok || fi.ModTime().After(mtime) {
+				mtime = fi.ModTime()
+			}
+			ok = true
 		}
-		fh.seq++
-		rotateFile = fmt.Sprintf(fileName, fh.filePath, fh.seq)
 	}
+	return mtime, ok
 }
 
 func (fh *FileHandler) rotateLog() (f *os.File, err error) {
@@ -187,13 +620,31 @@ func (fh *FileHandler) rotateLog() (f *os.File, err error) {
 		fh.out.Close()
 	}
 
+	if fh.minFree > 0 {
+		fh.relieveDiskPressure()
+	}
+
 	if fh.rotate > 0 {
 		if fh.seq > fh.rotate {
 			fh.seq = 1
 		}
 
 		rotateFileName := fmt.Sprintf("%v.%d", fh.filePath, fh.seq)
+		if fh.pattern != "" {
+			rotateFileName = fh.timestampedName()
+		}
 		if _, err := os.Stat(fh.filePath); !os.IsNotExist(err) {
+			if fh.pattern == "" {
+				// reusing a sequence no, remove the file of the previous cycle in either form
+				// so that at most rotate files are kept
+				fh.zipping.Wait()
+				for _, name := range []string{rotateFileName, rotateFileName + fh.compressor().Extension()} {
+					if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+						return nil, err
+					}
+				}
+			}
+
 			// rename/move only if it exist
 			err := os.Rename(fh.filePath, rotateFileName)
 			if err != nil {
@@ -202,10 +653,18 @@ func (fh *FileHandler) rotateLog() (f *os.File, err error) {
 
 			if fh.compress {
 				if _, err := os.Stat(rotateFileName); !os.IsNotExist(err) {
-					go compress(rotateFileName)
+					fh.zipping.Add(1)
+					c := fh.compressor()
+					go func() {
+						defer fh.zipping.Done()
+						if err := compress(c, rotateFileName); err != nil {
+							fh.reportError(err)
+						}
+					}()
 				}
 			}
 			fh.seq++
+			fh.rotated++
 		}
 	}
 
@@ -217,29 +676,389 @@ func (fh *FileHandler) rotateLog() (f *os.File, err error) {
 	return f, nil
 }
 
-func (fh *FileHandler) rotateDaily() {
+// timestampedName returns a free rotated log filename for the current time and name pattern.
+func (fh *FileHandler) timestampedName() string {
+	base := fh.filePath + "." + fh.now().Format(fh.pattern)
+	name := base
+	for i := 1; exists(name) || exists(name+fh.compressor().Extension()); i++ {
+		name = fmt.Sprintf("%s.%d", base, i)
+	}
+	return name
+}
+
+// exists returns true if the file exists.
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return !os.IsNotExist(err)
+}
+
+// relieveDiskPressure removes the oldest rotated log files while less than minFree bytes are
+// available. The caller must hold the lock.
+func (fh *FileHandler) relieveDiskPressure() {
+	free := fh.free
+	if free == nil {
+		free = freeSpace
+	}
+	dir := filepath.Dir(fh.filePath)
+	if n, err := free(dir); err != nil || n >= fh.minFree {
+		return
+	}
+
+	// wait for compressions, they would fail on removed files
+	fh.zipping.Wait()
+	for _, name := range fh.rotatedFiles() {
+		if err := os.Remove(name); err != nil {
+			fh.reportError(err)
+			continue
+		}
+		if n, err := free(dir); err != nil || n >= fh.minFree {
+			return
+		}
+	}
+}
+
+// rotatedFiles returns the rotated log files, oldest first.
+func (fh *FileHandler) rotatedFiles() []string {
+	dir, base := filepath.Split(fh.filePath)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil
+	}
+
+	type file struct {
+		name  string
+		mtime time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), base+".") || e.Name() == base+".lock" {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			files = append(files, file{filepath.Join(dir, e.Name()), fi.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.name
+	}
+	return names
+}
+
+// freeSpace returns the disk space available in dir.
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// rotateFile rotates the log file, switches to the new one and resets the byte count.
+// The caller must hold the lock.
+func (fh *FileHandler) rotateFile() error {
+	if err := fh.flushBuffer(); err != nil {
+		return err
+	}
+	if fh.rotate > 0 {
+		// the file is moved away complete
+		if err := fh.writeMarker(); err != nil {
+			return err
+		}
+	}
+	f, err := fh.rotateLog()
+	if err != nil {
+		return err
+	}
+	fh.written = 0
+	fh.out = f
+	if fh.buf != nil {
+		fh.buf.Reset(f)
+	}
+	return nil
+}
+
+// rotateOrReopen rotates the log file. A shared log file is only rotated if no other
+// process has rotated it since it was opened, otherwise the new log file is opened.
+// The processes take turns with a lock on the lock file next to the log file, held until
+// the log file is renamed and the new one opened, as the log file itself is replaced.
+func (fh *FileHandler) rotateOrReopen() error {
+	if !fh.shared {
+		return fh.rotateFile()
+	}
+
+	lock, err := os.OpenFile(fh.lockPath(), os.O_RDONLY|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	// closing the lock file releases the lock, on error too
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	if fh.isCurrent() {
+		// other processes may have used the sequence nos
+		fh.findSequence()
+		return fh.rotateFile()
+	}
+	return fh.reopen()
+}
+
+// lockPath returns the path of the lock file serializing the rotation of a shared log file.
+func (fh *FileHandler) lockPath() string {
+	return fh.filePath + ".lock"
+}
+
+// statShared updates the written bytes from the size of a shared log file and reopens
+// it if another process has rotated it.
+func (fh *FileHandler) statShared() error {
+	if !fh.isCurrent() {
+		return fh.reopen()
+	}
+	fi, err := fh.out.Stat()
+	if err != nil {
+		return err
+	}
+	fh.written = uint(fi.Size())
+	return nil
+}
+
+// checkExternalRotation reopens the log file if it has been moved away and updates the
+// written bytes if it has been truncated.
+func (fh *FileHandler) checkExternalRotation() error {
+	if !fh.isCurrent() {
+		return fh.reopen()
+	}
+	if fi, err := fh.out.Stat(); err == nil && uint(fi.Size()) < fh.written {
+		fh.written = uint(fi.Size())
+	}
+	return nil
+}
+
+// isCurrent returns true if the open log file is still the one at the log file path.
+func (fh *FileHandler) isCurrent() bool {
+	fi, err := os.Stat(fh.filePath)
+	if err != nil {
+		return false
+	}
+	out, err := fh.out.Stat()
+	return err == nil && os.SameFile(fi, out)
+}
+
+// reopen opens the file at the log file path, e.g. after another process rotated it.
+func (fh *FileHandler) reopen() error {
+	f, err := os.OpenFile(fh.filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	fh.flushBuffer()
+	fh.out.Close()
+	fh.out = f
+	if fh.buf != nil {
+		fh.buf.Reset(f)
+	}
+	fh.written = 0
+	if fi, err := f.Stat(); err == nil {
+		fh.written = uint(fi.Size())
+	}
+	return nil
+}
+
+// writeOnce writes b to f with a single write system call. It does not retry a short write.
+func writeOnce(f *os.File, b []byte) (n int, err error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	werr := rc.Write(func(fd uintptr) bool {
+		for {
+			if n, err = syscall.Write(int(fd), b); err != syscall.EINTR {
+				return true
+			}
+		}
+	})
+	if n < 0 {
+		n = 0
+	}
+	if werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// writeAll writes b to the log file, retrying the rest of b after a short write.
+// It returns the number of bytes written and an error if not all of b was written.
+func (fh *FileHandler) writeAll(b []byte) (n int, err error) {
+	write := fh.writeOut
+	if write == nil {
+		write = (*os.File).Write
+	}
+	for n < len(b) {
+		m, err := write(fh.out, b[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// flushBuffer writes out the buffered lines, if any.
+func (fh *FileHandler) flushBuffer() error {
+	if fh.buf == nil {
+		return nil
+	}
+	return fh.buf.Flush()
+}
+
+// flushTimed flushes the buffer every flush interval until stop is closed.
+func (fh *FileHandler) flushTimed(stop <-chan struct{}) {
+	defer fh.timers.Done()
 	for {
-		h, m, s := time.Now().Clock()
-		d := time.Duration((24-h)*3600-m*60-1*s) * time.Second
-		t := time.NewTimer(d)
+		fh.mutex.Lock()
+		clock, interval := fh.clock, fh.flushIn
+		fh.mutex.Unlock()
+
 		select {
-		case <-t.C:
-			f, err := fh.rotateLog()
-			if err != nil {
-				_ = fmt.Errorf("Failed to rotate log daily: %v", err)
-			}
-			fh.written = 0
-			fh.out = f
+		case <-clock.After(interval):
+		case <-stop:
+			return
 		}
-		if !fh.daily {
-			break
+
+		fh.mutex.Lock()
+		if stopped(stop) {
+			fh.mutex.Unlock()
+			return
+		}
+		err := fh.flushBuffer()
+		fh.mutex.Unlock()
+
+		if err != nil {
+			fh.reportError(fmt.Errorf("Failed to flush the log buffer: %v", err))
 		}
 	}
 }
 
-func compress(filePath string) {
-	err := exec.Command("gzip", "-f", filePath).Run()
-	if err != nil {
-		_ = fmt.Errorf("%v", err)
+// writeMarker ends the log file with the close marker, if set.
+// The caller must hold the lock.
+func (fh *FileHandler) writeMarker() error {
+	if fh.out == nil {
+		return nil
+	}
+	return writeCloseMarker(fh.out, fh.marker)
+}
+
+// writeCloseMarker writes the close marker to f, if set.
+func writeCloseMarker(f *os.File, marker string) error {
+	if marker == "" {
+		return nil
+	}
+	_, err := f.WriteString(marker)
+	return err
+}
+
+// restartTimer stops the running rotation timer and starts a new one if the log file
+// rotates daily or at an interval and the handler is open. The caller must hold the lock.
+func (fh *FileHandler) restartTimer() {
+	stopTimer(&fh.rotateC)
+	if fh.out != nil && (fh.daily || fh.interval > 0) {
+		fh.rotateC = make(chan struct{})
+		fh.timers.Add(1)
+		go fh.rotateTimed(fh.rotateC)
+	}
+}
+
+// stopTimer stops the timer waiting on *stop, if any. The caller must hold the lock.
+func stopTimer(stop *chan struct{}) {
+	if *stop != nil {
+		close(*stop)
+		*stop = nil
+	}
+}
+
+// stopped reports whether stop is closed.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// rotateTimed rotates the log file at each rotation time until stop is closed.
+func (fh *FileHandler) rotateTimed(stop <-chan struct{}) {
+	defer fh.timers.Done()
+	for {
+		fh.mutex.Lock()
+		clock := fh.clock
+		// a new timer each time, computed from the current time
+		now := clock.Now()
+		next := fh.nextRotation(now)
+		fh.mutex.Unlock()
+
+		select {
+		case <-clock.After(next.Sub(now)):
+		case <-stop:
+			return
+		}
+
+		fh.mutex.Lock()
+		if stopped(stop) {
+			fh.mutex.Unlock()
+			return
+		}
+		daily := fh.daily
+		err := fh.rotateOrReopen()
+		fh.mutex.Unlock()
+
+		if err != nil && daily {
+			fh.reportError(fmt.Errorf("Failed to rotate log daily: %v", err))
+		} else if err != nil {
+			fh.reportError(fmt.Errorf("Failed to rotate log at interval: %v", err))
+		}
+	}
+}
+
+// nextRotation returns the time of the next time based rotation after now.
+// The caller must hold the lock.
+func (fh *FileHandler) nextRotation(now time.Time) time.Time {
+	if fh.utc {
+		now = now.UTC()
+	}
+	if fh.daily {
+		return nextMidnight(now)
+	}
+	return now.Truncate(fh.interval).Add(fh.interval)
+}
+
+// nextMidnight returns the start of the day after t in t's location.
+func nextMidnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// now returns the clock time, in UTC if set.
+func (fh *FileHandler) now() time.Time {
+	if fh.utc {
+		return fh.clock.Now().UTC()
+	}
+	return fh.clock.Now()
+}
+
+func (fh *FileHandler) reportError(err error) {
+	fh.errMutex.Lock()
+	fn := fh.onError
+	fh.errMutex.Unlock()
+
+	if fn == nil {
+		fmt.Fprintf(os.Stderr, "FileHandler %s: %v\n", fh.filePath, err)
+		return
 	}
+	fn(err)
 }
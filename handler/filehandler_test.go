@@ -0,0 +1,1051 @@
+package handler
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNextMidnight(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*3600)
+	for _, tc := range []struct {
+		now, want time.Time
+	}{
+		{time.Date(2013, 6, 21, 8, 21, 44, 0, loc), time.Date(2013, 6, 22, 0, 0, 0, 0, loc)},
+		{time.Date(2013, 6, 21, 23, 59, 59, 999, loc), time.Date(2013, 6, 22, 0, 0, 0, 0, loc)},
+		{time.Date(2013, 6, 21, 0, 0, 0, 0, loc), time.Date(2013, 6, 22, 0, 0, 0, 0, loc)},
+		{time.Date(2013, 12, 31, 23, 30, 0, 0, loc), time.Date(2014, 1, 1, 0, 0, 0, 0, loc)},
+	} {
+		got := nextMidnight(tc.now)
+		if !got.Equal(tc.want) || got.Location() != loc {
+			t.Errorf("nextMidnight(%v) = %v, want %v", tc.now, got, tc.want)
+		}
+		if d := got.Sub(tc.now); d <= 0 || d > 24*time.Hour {
+			t.Errorf("Unexpected interval %v until midnight from %v", d, tc.now)
+		}
+	}
+}
+
+// fakeClock is a manually advanced Clock.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	c := make(chan time.Time, 1)
+	fc.timers = append(fc.timers, fakeTimer{fc.now.Add(d), c})
+	return c
+}
+
+// Advance moves the clock forward and fires the timers that are due.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.now = fc.now.Add(d)
+	pending := fc.timers[:0]
+	for _, t := range fc.timers {
+		if t.at.After(fc.now) {
+			pending = append(pending, t)
+		} else {
+			t.c <- fc.now
+		}
+	}
+	fc.timers = pending
+}
+
+// waitTimers waits until n timers are pending.
+func (fc *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		fc.mutex.Lock()
+		pending := len(fc.timers)
+		fc.mutex.Unlock()
+		if pending == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d pending timers", n)
+}
+
+func TestDailyRotationWithClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClock{now: time.Date(2013, 6, 21, 23, 59, 58, 0, time.Local)}
+	fh.SetClock(fc)
+	fh.SetDaily(true)
+	fc.waitTimers(t, 1)
+
+	fh.Write([]byte("day 1\n"))
+	fc.Advance(time.Second)
+	if exists(path + ".1") {
+		t.Fatal("Rotated before midnight")
+	}
+
+	fc.Advance(time.Second)
+	fc.waitTimers(t, 1)
+	if !exists(path+".1") || exists(path+".2") {
+		t.Fatal("Expected exactly one rotation at midnight")
+	}
+
+	fh.Write([]byte("day 2\n"))
+	fc.Advance(24*time.Hour - time.Second)
+	fc.waitTimers(t, 1)
+	if exists(path + ".2") {
+		t.Fatal("Rotated before the next midnight")
+	}
+	fc.Advance(time.Second)
+	fc.waitTimers(t, 1)
+	if !exists(path+".2") || exists(path+".3") {
+		t.Fatal("Expected exactly one rotation the next day")
+	}
+}
+
+func TestDailyRotationUTC(t *testing.T) {
+	fh, err := NewFileHandler(filepath.Join(t.TempDir(), "utc.log"), 0, 5, 1, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	// 01:00 local is 23:00 UTC the day before
+	now := time.Date(2013, 6, 21, 1, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	if next := fh.nextRotation(now); !next.Equal(time.Date(2013, 6, 22, 0, 0, 0, 0, now.Location())) {
+		t.Errorf("Expected local midnight, got %v", next)
+	}
+	fh.SetUTC(true)
+	if next := fh.nextRotation(now); !next.Equal(time.Date(2013, 6, 21, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected midnight UTC, got %v", next)
+	}
+}
+
+func TestRotateInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hourly.log")
+	fh, err := NewFileHandler(path, 100, 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClock{now: time.Date(2013, 6, 21, 10, 59, 58, 0, time.Local)}
+	fh.SetClock(fc)
+	fh.SetRotateInterval(time.Hour)
+	fc.waitTimers(t, 1)
+
+	fh.Write([]byte("hour 10\n"))
+	fc.Advance(time.Second)
+	if exists(path + ".1") {
+		t.Fatal("Rotated before the hour")
+	}
+
+	fc.Advance(time.Second)
+	fc.waitTimers(t, 1)
+	if !exists(path+".1") || exists(path+".2") {
+		t.Fatal("Expected exactly one rotation at 11:00")
+	}
+
+	fh.Write([]byte("hour 11\n"))
+	fc.Advance(time.Hour - time.Second)
+	fc.waitTimers(t, 1)
+	if exists(path + ".2") {
+		t.Fatal("Rotated before 12:00")
+	}
+	fc.Advance(time.Second)
+	fc.waitTimers(t, 1)
+	if !exists(path+".2") || exists(path+".3") {
+		t.Fatal("Expected exactly one rotation at 12:00")
+	}
+
+	// size based rotation still applies within the hour
+	fh.Write([]byte(strings.Repeat("x", 100)))
+	if !exists(path + ".3") {
+		t.Error("Expected a size based rotation between the hours")
+	}
+
+	fh.SetRotateInterval(0)
+	fc.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if exists(path + ".4") {
+		t.Error("Rotated after the interval was disabled")
+	}
+}
+
+func TestCloseStopsRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "closed.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClock{now: time.Date(2013, 6, 21, 10, 59, 58, 0, time.Local)}
+	fh.SetClock(fc)
+	fh.SetRotateInterval(time.Hour)
+	fc.waitTimers(t, 1)
+
+	if err := fh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fc.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if exists(path + ".1") {
+		t.Error("Rotated after Close")
+	}
+
+	if _, err := fh.Write([]byte("after close\n")); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed from Write, got %v", err)
+	}
+	if err := fh.RotateNow(); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed from RotateNow, got %v", err)
+	}
+	if err := fh.Flush(); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed from Flush, got %v", err)
+	}
+	if err := fh.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); strings.Contains(string(b), "after close") {
+		t.Error("Written after Close")
+	}
+}
+
+func TestTimersExitOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timers.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClock{now: time.Date(2013, 6, 21, 10, 59, 58, 0, time.Local)}
+	fh.SetClock(fc)
+	fh.SetRotateInterval(time.Hour)
+	fh.SetDaily(true) // replaces the interval timer
+	if err := fh.SetBuffered(4096, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	fc.waitTimers(t, 3)
+
+	if err := fh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		fh.timers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timer goroutines still running after Close")
+	}
+}
+
+func TestWrittenAndRotationCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.log")
+	fh, err := NewFileHandler(path, 10, 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	fh.Write([]byte("12345"))
+	if n := fh.Written(); n != 5 {
+		t.Errorf("Expected 5 bytes written, got %d", n)
+	}
+	if n := fh.RotationCount(); n != 0 {
+		t.Errorf("Expected no rotations, got %d", n)
+	}
+
+	for i := uint64(1); i <= 2; i++ {
+		fh.Write([]byte("1234567890"))
+		if n := fh.Written(); n != 0 {
+			t.Errorf("Expected the byte count to reset after rotation %d, got %d", i, n)
+		}
+		if n := fh.RotationCount(); n != i {
+			t.Errorf("Expected %d rotations, got %d", i, n)
+		}
+	}
+}
+
+func TestShortWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	// at most 3 bytes per write, the rest of the line is retried
+	fh.writeOut = func(f *os.File, b []byte) (int, error) {
+		if len(b) > 3 {
+			b = b[:3]
+		}
+		return f.Write(b)
+	}
+	if n, err := fh.Write([]byte("a short write\n")); err != nil || n != 14 {
+		t.Errorf("Expected the line written in full, got %d, %v", n, err)
+	}
+
+	// a failing write after 4 bytes is counted too
+	errFull := fmt.Errorf("disk full")
+	fh.writeOut = func(f *os.File, b []byte) (int, error) {
+		n, _ := f.Write(b[:4])
+		return n, errFull
+	}
+	if n, err := fh.Write([]byte("cut off\n")); !errors.Is(err, errFull) || n != 4 {
+		t.Errorf("Expected 4 bytes and the write error, got %d, %v", n, err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fh.Written() != uint(fi.Size()) || fi.Size() != 18 {
+		t.Errorf("Expected 18 bytes counted and on disk, got %d and %d", fh.Written(), fi.Size())
+	}
+}
+
+func TestCloseMarker(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "marked.log")
+	fh, err := NewFileHandler(path, 10, 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.SetCloseMarker("-- log closed --")
+	fh.Write([]byte("rotated 1\n"))
+	fh.Write([]byte("last\n"))
+	if err := fh.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		path:        "last\n-- log closed --\n",
+		path + ".1": "rotated 1\n-- log closed --\n",
+	} {
+		if b, _ := ioutil.ReadFile(name); string(b) != want {
+			t.Errorf("%s: got %q, want %q", name, b, want)
+		}
+	}
+
+	// abandoned without Close, e.g. on a crash
+	crashed := filepath.Join(dir, "crashed.log")
+	fh, err = NewFileHandler(crashed, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.SetCloseMarker("-- log closed --")
+	fh.Write([]byte("last\n"))
+	if b, _ := ioutil.ReadFile(crashed); string(b) != "last\n" {
+		t.Errorf("Unexpected marker in an abandoned file: %q", b)
+	}
+}
+
+func TestCompressRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zip.log")
+	fh, err := NewFileHandler(path, 10, 2, 1, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	fh.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	line := "hello compressed world\n"
+	if _, err := fh.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	fh.zipping.Wait()
+
+	if len(errs) > 0 {
+		t.Fatalf("Compression failed: %v", errs)
+	}
+	if exists(path + ".1") {
+		t.Error("The uncompressed rotated file should be removed")
+	}
+
+	f, err := os.Open(path + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Invalid gzip file: %v", err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != line {
+		t.Errorf("Expected %q after decompression, got %q", line, b)
+	}
+}
+
+func TestDailyRotationError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	fh.SetErrorHandler(func(err error) { errs <- err })
+	fc := &fakeClock{now: time.Date(2013, 6, 21, 23, 59, 59, 0, time.Local)}
+	fh.SetClock(fc)
+	fh.SetDaily(true)
+	fc.waitTimers(t, 1)
+
+	// a non-empty directory in place of the rotated file makes the rename fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	fc.Advance(time.Second)
+
+	select {
+	case err := <-errs:
+		t.Logf("Got rotation error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the error handler to receive the rotation error")
+	}
+}
+
+func TestConcurrentWriteAndRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "race.log")
+	fh, err := NewFileHandler(path, uint(MB), 100, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, lines = 8, 200
+	line := []byte("a line that must not get lost\n")
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*lines)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				if _, err := fh.Write(line); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		fh.mutex.Lock()
+		if err := fh.rotateFile(); err != nil {
+			t.Error(err)
+		}
+		fh.mutex.Unlock()
+		time.Sleep(100 * time.Microsecond)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Write failed: %v", err)
+	}
+
+	matches, _ := filepath.Glob(path + "*")
+	var total int64
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += fi.Size()
+	}
+	if want := int64(writers * lines * len(line)); total != want {
+		t.Errorf("Expected %d bytes on disk, got %d", want, total)
+	}
+}
+
+func TestSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.log")
+	// two handlers on the same file, as two processes would have
+	var fhs []*FileHandler
+	for i := 0; i < 2; i++ {
+		fh, err := NewFileHandler(path, 64*uint(KB), 50, 1, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fh.SetSharedFile(true)
+		defer fh.Close()
+		fhs = append(fhs, fh)
+	}
+
+	const lines = 200
+	var wg sync.WaitGroup
+	for i, fh := range fhs {
+		wg.Add(1)
+		go func(i int, fh *FileHandler) {
+			defer wg.Done()
+			line := []byte(fmt.Sprintf("writer %d %s\n", i, strings.Repeat(string(rune('a'+i)), 1000)))
+			for j := 0; j < lines; j++ {
+				if _, err := fh.Write(line); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i, fh)
+	}
+	wg.Wait()
+
+	matches, _ := filepath.Glob(path + "*")
+	if len(matches) < 2 {
+		t.Errorf("Expected the shared file to be rotated, got %q", matches)
+	}
+	count := 0
+	for _, m := range matches {
+		b, err := ioutil.ReadFile(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if l == "" {
+				continue
+			}
+			count++
+			if l != "writer 0 "+strings.Repeat("a", 1000) && l != "writer 1 "+strings.Repeat("b", 1000) {
+				t.Fatalf("Torn line in %s: %.40q", m, l)
+			}
+		}
+	}
+	if count != 2*lines {
+		t.Errorf("Expected %d lines, got %d", 2*lines, count)
+	}
+}
+
+func TestSharedFileRotationLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	fh.SetSharedFile(true)
+
+	// another process holds the lock while it rotates the log file
+	lock, err := os.OpenFile(fh.lockPath(), os.O_RDONLY|os.O_CREATE, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- fh.RotateNow() }()
+
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Rotated while the lock was held: %v", err)
+	default:
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	lock.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if exists(path + ".2") {
+		t.Error("Rotated again after the other process rotated")
+	}
+	fh.Write([]byte("reopened\n"))
+	if b, _ := ioutil.ReadFile(path); string(b) != "reopened\n" {
+		t.Errorf("Expected the line in the new log file, got %q", b)
+	}
+}
+
+func TestReopenInterval(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	fh, err := NewFileHandler(path, 0, 0, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	fh.SetReopenInterval(time.Nanosecond)
+
+	fh.Write([]byte("before\n"))
+	// logrotate moves the file away, the next line goes to a new file
+	if err := os.Rename(path, filepath.Join(dir, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	fh.Write([]byte("after\n"))
+
+	if b, _ := ioutil.ReadFile(path); string(b) != "after\n" {
+		t.Errorf("Expected the new file to get the line, got %q", b)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "app.log.1")); string(b) != "before\n" {
+		t.Errorf("Unexpected moved file %q", b)
+	}
+}
+
+func TestNewFileHandlerValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "valid.log")
+	for _, tc := range []struct {
+		name     string
+		path     string
+		size     uint
+		rotate   byte
+		seq      byte
+		compress bool
+		daily    bool
+		interval time.Duration // NewIntervalFileHandler if set
+		invalid  string
+	}{
+		{"baseline", path, uint(MB), 5, 1, true, false, 0, ""},
+		{"no rotation", path, 0, 0, 0, false, false, 0, ""},
+		{"start seq 0", path, uint(MB), 5, 0, false, false, 0, ""},
+		{"daily without size", path, 0, 5, 1, false, true, 0, ""},
+		{"interval without size", path, 0, 5, 1, false, false, time.Hour, ""},
+		{"interval and size", path, uint(MB), 5, 1, false, false, time.Hour, ""},
+		{"empty path", "", uint(MB), 5, 1, false, false, 0, "no file path"},
+		{"start seq above rotations", path, uint(MB), 5, 6, false, false, 0, "start sequence no 6"},
+		{"size without rotation", path, uint(MB), 0, 0, false, false, 0, "max size"},
+		{"compress without rotation", path, 0, 0, 0, true, false, 0, "compression"},
+		{"daily without rotation", path, 0, 0, 0, false, true, 0, "daily rotation"},
+		{"interval without rotation", path, 0, 0, 0, false, false, time.Hour, "interval rotation"},
+		{"rotation without trigger", path, 0, 5, 1, false, false, 0, "rotation with no max size, daily or interval rotation"},
+		{"rotation without trigger, compressed", path, 0, 5, 1, true, false, 0, "rotation with no max size"},
+	} {
+		var fh *FileHandler
+		var err error
+		if tc.interval > 0 {
+			fh, err = NewIntervalFileHandler(tc.path, tc.size, tc.rotate, tc.seq, tc.compress, tc.interval)
+		} else {
+			fh, err = NewFileHandler(tc.path, tc.size, tc.rotate, tc.seq, tc.compress, tc.daily)
+		}
+		if tc.invalid == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tc.name, err)
+			} else {
+				fh.Close()
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.invalid) {
+			t.Errorf("%s: expected an error with %q, got %v", tc.name, tc.invalid, err)
+		}
+	}
+
+	if _, err := NewIntervalFileHandler(path, 0, 5, 1, false, 0); err == nil || !strings.Contains(err.Error(), "rotation interval") {
+		t.Errorf("Expected an error for a zero interval, got %v", err)
+	}
+}
+
+func TestSetSeq(t *testing.T) {
+	fh, err := NewFileHandler(filepath.Join(t.TempDir(), "seq.log"), uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	for _, seq := range []byte{0, 6, 200} {
+		if err := fh.SetSeq(seq); err == nil {
+			t.Errorf("Expected an error for sequence no %d", seq)
+		}
+	}
+	if fh.Seq() != 1 {
+		t.Errorf("Expected the sequence no unchanged, got %d", fh.Seq())
+	}
+	for _, seq := range []byte{1, 3, 5} {
+		if err := fh.SetSeq(seq); err != nil || fh.Seq() != seq {
+			t.Errorf("SetSeq(%d) = %v, sequence no %d", seq, err, fh.Seq())
+		}
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffered.log")
+	fh, err := NewFileHandler(path, 0, 0, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClock{now: time.Date(2013, 6, 21, 8, 0, 0, 0, time.Local)}
+	fh.SetClock(fc)
+	fh.SetBuffered(64*int(KB), time.Second)
+	fc.waitTimers(t, 1)
+
+	line := []byte("a buffered line\n")
+	for i := 0; i < 100; i++ {
+		fh.Write(line)
+	}
+	size := func() int64 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	if n := size(); n != 0 {
+		t.Fatalf("Expected the lines to be buffered, %d bytes on disk", n)
+	}
+
+	fc.Advance(time.Second)
+	fc.waitTimers(t, 1)
+	if n, want := size(), int64(100*len(line)); n != want {
+		t.Fatalf("Expected %d bytes on disk after the flush interval, got %d", want, n)
+	}
+
+	fh.Write(line)
+	if err := fh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, want := size(), int64(101*len(line)); n != want {
+		t.Errorf("Expected %d bytes on disk after Close, got %d", want, n)
+	}
+}
+
+func TestFileHandlerVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verify.log")
+	fh, err := NewStdFileHandler(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Write([]byte("existing line\n"))
+
+	if err := fh.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	fh.Write([]byte("next line\n"))
+
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != "existing line\nnext line\n" {
+		t.Errorf("Expected Verify to leave the log file alone, got %q", b)
+	}
+
+	os.Remove(path)
+	if err := fh.Verify(); err == nil {
+		t.Error("Expected Verify to fail for a removed log file")
+	}
+	fh.Close()
+}
+
+func TestRotateNow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "now.log")
+	fh, err := NewFileHandler(path, uint(MB), 3, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	fh.Write([]byte("old\n"))
+	if err = fh.RotateNow(); err != nil {
+		t.Fatalf("RotateNow failed: %v", err)
+	}
+	fh.Write([]byte("new\n"))
+
+	if b, _ := os.ReadFile(path + ".1"); string(b) != "old\n" {
+		t.Errorf("Unexpected rotated content %q", b)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "new\n" {
+		t.Errorf("Unexpected current content %q", b)
+	}
+}
+
+func TestNamePattern(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	fc := &fakeClock{now: time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)}
+	fh.SetClock(fc)
+	fh.SetNamePattern("2006-01-02T15-04-05")
+
+	for i := 0; i < 3; i++ {
+		fh.Write([]byte(fmt.Sprintf("line %d\n", i)))
+		if err = fh.RotateNow(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fc.Advance(time.Second)
+	fh.Write([]byte("line 3\n"))
+	fh.RotateNow()
+
+	for i, name := range []string{"app.log.2024-01-02T15-04-05", "app.log.2024-01-02T15-04-05.1", "app.log.2024-01-02T15-04-05.2", "app.log.2024-01-02T15-04-06"} {
+		b, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			t.Errorf("Missing rotated file: %v", err)
+			continue
+		}
+		if want := fmt.Sprintf("line %d\n", i); string(b) != want {
+			t.Errorf("Unexpected content of %s: %q", name, b)
+		}
+	}
+	if exists(path + ".1") {
+		t.Error("Rotated with a sequence number")
+	}
+}
+
+func TestRotationLimit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "limit.log")
+	fh, err := NewFileHandler(path, uint(MB), 3, 1, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	for i := 0; i < 10; i++ {
+		fh.Write([]byte(fmt.Sprintf("line %d\n", i)))
+		// switch compression to leave both forms behind
+		fh.SetCompress(i%4 != 0)
+		if err = fh.RotateNow(); err != nil {
+			t.Fatal(err)
+		}
+		fh.zipping.Wait()
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 3 {
+		t.Errorf("Expected 3 rotated files, got %v", rotated)
+	}
+}
+
+func TestFindSequenceReusesOldest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	start := time.Now().Add(-time.Hour)
+	// app.log.3 is the oldest
+	for seq, age := range map[int]int{1: 3, 2: 2, 3: 5, 4: 1, 5: 4} {
+		name := fmt.Sprintf("%s.%d", path, seq)
+		if err := os.WriteFile(name, []byte(name), 0640); err != nil {
+			t.Fatal(err)
+		}
+		mtime := start.Add(-time.Duration(age) * time.Minute)
+		os.Chtimes(name, mtime, mtime)
+	}
+
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	fh.Write([]byte("new\n"))
+	if err = fh.RotateNow(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := os.ReadFile(path + ".3"); string(b) != "new\n" {
+		t.Errorf("Oldest slot not reused: %q", b)
+	}
+	if exists(path + ".6") {
+		t.Error("Rotated past the limit")
+	}
+	for _, seq := range []int{1, 2, 4, 5} {
+		name := fmt.Sprintf("%s.%d", path, seq)
+		if b, _ := os.ReadFile(name); string(b) != name {
+			t.Errorf("Rotated file %d overwritten: %q", seq, b)
+		}
+	}
+}
+
+func TestCompressLevel(t *testing.T) {
+	var input []byte
+	for i := 0; i < 20000; i++ {
+		input = append(input, fmt.Sprintf("2013/06/21 08:21:44 info request %d took %dms\n", i, i*7%1000)...)
+	}
+
+	dir := t.TempDir()
+	sizes := make(map[int]int64)
+	for _, level := range []int{gzip.BestSpeed, gzip.BestCompression} {
+		path := filepath.Join(dir, fmt.Sprintf("level%d.log", level))
+		if err := os.WriteFile(path, input, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := compress(GzipCompressor{Level: level}, path); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(path + ".gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, _ := f.Stat()
+		sizes[level] = fi.Size()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("Invalid gzip file: %v", err)
+		}
+		b, err := ioutil.ReadAll(zr)
+		f.Close()
+		if err != nil || string(b) != string(input) {
+			t.Errorf("Level %d does not decompress to the input: %v", level, err)
+		}
+	}
+	if sizes[gzip.BestSpeed] <= sizes[gzip.BestCompression] {
+		t.Errorf("Expected BestSpeed to be larger than BestCompression: %v", sizes)
+	}
+
+	fh, err := NewFileHandler(filepath.Join(dir, "app.log"), uint(MB), 1, 1, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	if fh.CompressLevel() != gzip.DefaultCompression {
+		t.Errorf("Unexpected default level %d", fh.CompressLevel())
+	}
+	if err = fh.SetCompressLevel(10); err == nil {
+		t.Error("Expected an error for level 10")
+	}
+	if err = fh.SetCompressLevel(gzip.BestSpeed); err != nil || fh.CompressLevel() != gzip.BestSpeed {
+		t.Errorf("Unable to set level: %v", err)
+	}
+}
+
+func TestDiskPressure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	fh, err := NewFileHandler(path, uint(MB), 5, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	start := time.Now().Add(-time.Hour)
+	for seq := 2; seq <= 5; seq++ {
+		name := fmt.Sprintf("%s.%d", path, seq)
+		os.WriteFile(name, []byte("old\n"), 0640)
+		mtime := start.Add(time.Duration(seq) * time.Minute)
+		os.Chtimes(name, mtime, mtime)
+	}
+
+	// plenty of space until two files are removed
+	checks := 0
+	fh.free = func(dir string) (uint64, error) {
+		checks++
+		if checks <= 2 {
+			return 100, nil
+		}
+		return 1 << 30, nil
+	}
+
+	fh.RotateNow()
+	if checks != 0 {
+		t.Errorf("Disk space checked while the check is disabled")
+	}
+	fh.SetDiskPressureCheck(1 << 20)
+	fh.RotateNow()
+
+	// the two oldest, 2 and 3, are removed and 2 is reused for the rotated log
+	if exists(path + ".3") {
+		t.Error("Rotated file 3 not removed")
+	}
+	for _, seq := range []int{4, 5} {
+		if b, _ := os.ReadFile(fmt.Sprintf("%s.%d", path, seq)); string(b) != "old\n" {
+			t.Errorf("Rotated file %d removed", seq)
+		}
+	}
+	if b, err := os.ReadFile(path + ".2"); err != nil || string(b) == "old\n" {
+		t.Errorf("Rotated file 2 not replaced: %q %v", b, err)
+	}
+}
+
+// copyCompressor "compresses" by copying.
+type copyCompressor struct {
+	calls int
+}
+
+func (cc *copyCompressor) Compress(src, dst string) error {
+	cc.calls++
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, 0640)
+}
+
+func (cc *copyCompressor) Extension() string {
+	return ".copy"
+}
+
+func TestCompressor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	fh, err := NewFileHandler(path, uint(MB), 2, 1, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	cc := &copyCompressor{}
+	fh.SetCompressor(cc)
+
+	for i := 0; i < 3; i++ {
+		fh.Write([]byte(fmt.Sprintf("line %d\n", i)))
+		fh.RotateNow()
+		fh.zipping.Wait()
+	}
+
+	if cc.calls != 3 {
+		t.Errorf("Expected 3 compressions, got %d", cc.calls)
+	}
+	rotated, _ := filepath.Glob(path + ".*")
+	want := []string{path + ".1.copy", path + ".2.copy"}
+	if fmt.Sprint(rotated) != fmt.Sprint(want) {
+		t.Errorf("Unexpected rotated files %v", rotated)
+	}
+	// the third rotation reused slot 1
+	if b, _ := os.ReadFile(path + ".1.copy"); string(b) != "line 2\n" {
+		t.Errorf("Unexpected content %q", b)
+	}
+
+	if err := fh.SetCompressor(&noExtCompressor{}); err == nil {
+		t.Error("Expected an error for a compressor without extension")
+	}
+}
+
+// noExtCompressor has no filename extension.
+type noExtCompressor struct{ copyCompressor }
+
+func (*noExtCompressor) Extension() string {
+	return ""
+}
+
+func TestGzipCompressorZeroValue(t *testing.T) {
+	input := []byte(strings.Repeat("2013/06/21 08:21:44 info request served\n", 1000))
+	dir := t.TempDir()
+	sizes := make(map[string]int64)
+	for name, c := range map[string]Compressor{"zero": GzipCompressor{}, "default": GzipCompressor{Level: gzip.DefaultCompression}} {
+		path := filepath.Join(dir, name+".log")
+		if err := os.WriteFile(path, input, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := compress(c, path); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path + ".gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[name] = fi.Size()
+	}
+	if sizes["zero"] != sizes["default"] {
+		t.Errorf("Expected the zero value to use the default level: %v", sizes)
+	}
+}
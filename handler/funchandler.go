@@ -0,0 +1,32 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+// FuncHandler adapts a function to the Handler interface, e.g. as a hook posting
+// crit lines to an alerting webhook when added with a severity filter.
+type FuncHandler struct {
+	fn func(b []byte) (int, error)
+}
+
+// NewFuncHandler returns a handler calling fn with each log message.
+// fn must not retain b after it returns.
+func NewFuncHandler(fn func(b []byte) (int, error)) *FuncHandler {
+	return &FuncHandler{fn: fn}
+}
+
+// Write passes the log message to the function.
+func (fh *FuncHandler) Write(b []byte) (n int, err error) {
+	return fh.fn(b)
+}
+
+// Close handler.
+func (fh *FuncHandler) Close() error {
+	return nil
+}
+
+// String returns the handler name.
+func (fh *FuncHandler) String() string {
+	return "FuncHandler"
+}
@@ -6,6 +6,7 @@ package handler
 
 import (
 	"errors"
+	"fmt"
 	"os"
 )
 
@@ -25,6 +26,48 @@ const (
 	YB
 )
 
+// Errors returned by handlers, wrapped in a HandlerError.
+var (
+	// ErrShortWrite is returned if only part of a log line could be written.
+	ErrShortWrite = errors.New("Unable to write all bytes")
+	// ErrHandlerClosed is returned when writing to a closed handler.
+	ErrHandlerClosed = errors.New("Handler is closed")
+)
+
+// HandlerError is returned by a handler that failed to write. Use errors.Is to
+// check for ErrShortWrite or ErrHandlerClosed and errors.As to get the handler.
+type HandlerError struct {
+	Handler string // handler name, e.g. "FileHandler"
+	Dest    string // destination, e.g. the log file path, if any
+	Err     error  // the cause
+}
+
+func (e *HandlerError) Error() string {
+	if e.Dest == "" {
+		return e.Handler + ": " + e.Err.Error()
+	}
+	return e.Handler + " " + e.Dest + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause.
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// handlerError wraps err in a HandlerError for h, nil stays nil.
+func handlerError(h Handler, dest string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*HandlerError); ok {
+		return err
+	}
+	if errors.Is(err, os.ErrClosed) {
+		err = ErrHandlerClosed
+	}
+	return &HandlerError{Handler: h.String(), Dest: dest, Err: err}
+}
+
 // Handler is an interface to different log/logger handlers.
 type Handler interface {
 	// Writer interface
@@ -35,6 +78,40 @@ type Handler interface {
 	String() string
 }
 
+// Verifier is implemented by handlers that can check that their destination is
+// writable without leaving anything behind.
+type Verifier interface {
+	Verify() error
+}
+
+// Describer is implemented by handlers that can describe where and how they write,
+// e.g. "file /var/log/app.log (max 10MB, 5 rotations, gzip)".
+type Describer interface {
+	Describe() string
+}
+
+// Flusher is implemented by handlers that buffer or queue lines and can write them
+// out on request without being closed.
+type Flusher interface {
+	Flush() error
+}
+
+// Flush writes out the pending lines of h if it is a Flusher, otherwise it does nothing.
+func Flush(h Handler) error {
+	if f, ok := h.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Describe returns the description of h if it is a Describer, otherwise its name.
+func Describe(h Handler) string {
+	if d, ok := h.(Describer); ok {
+		return d.Describe()
+	}
+	return h.String()
+}
+
 // NoopHandler is a dummy handler used for a new logger instance. Log to noop.
 type NoopHandler struct {
 }
@@ -62,13 +139,28 @@ func (nh *NoopHandler) String() string {
 	return "NoopHandler"
 }
 
+// NewStdoutHandler returns a handler for os.Stdout. An error is returned if
+// os.Stdout is closed or otherwise unusable, e.g. in a daemon without a console.
+func NewStdoutHandler() (*StdoutHandler, error) {
+	if _, err := os.Stdout.Stat(); err != nil {
+		return nil, fmt.Errorf("Unable to use stdout: %v", err)
+	}
+	return &StdoutHandler{}, nil
+}
+
 // Write a log message.
 func (ch *StdoutHandler) Write(b []byte) (n int, err error) {
 	n, err = os.Stdout.Write(b)
-	if n < len(b) {
-		return n, errors.New("Unable to write all bytes to stdout")
+	if err == nil && n < len(b) {
+		err = ErrShortWrite
 	}
-	return n, err
+	return n, handlerError(ch, "", err)
+}
+
+// Verify checks that stdout is writable with an empty write, which writes nothing.
+func (ch *StdoutHandler) Verify() error {
+	_, err := os.Stdout.Write(nil)
+	return handlerError(ch, "", err)
 }
 
 // Close handler.
@@ -81,13 +173,33 @@ func (ch *StdoutHandler) String() string {
 	return "StdoutHandler"
 }
 
+// Describe returns the destination.
+func (ch *StdoutHandler) Describe() string {
+	return "stdout"
+}
+
+// NewStderrHandler returns a handler for os.Stderr. An error is returned if
+// os.Stderr is closed or otherwise unusable.
+func NewStderrHandler() (*StderrHandler, error) {
+	if _, err := os.Stderr.Stat(); err != nil {
+		return nil, fmt.Errorf("Unable to use stderr: %v", err)
+	}
+	return &StderrHandler{}, nil
+}
+
 // Write a log message.
 func (ch *StderrHandler) Write(b []byte) (n int, err error) {
 	n, err = os.Stderr.Write(b)
-	if n < len(b) {
-		return n, errors.New("Unable to write all bytes to stdout")
+	if err == nil && n < len(b) {
+		err = ErrShortWrite
 	}
-	return n, err
+	return n, handlerError(ch, "", err)
+}
+
+// Verify checks that stderr is writable with an empty write, which writes nothing.
+func (ch *StderrHandler) Verify() error {
+	_, err := os.Stderr.Write(nil)
+	return handlerError(ch, "", err)
 }
 
 // Close handler.
@@ -99,3 +211,21 @@ func (ch *StderrHandler) Close() error {
 func (ch *StderrHandler) String() string {
 	return "StderrHandler"
 }
+
+// Describe returns the destination.
+func (ch *StderrHandler) Describe() string {
+	return "stderr"
+}
+
+// formatSize returns size in the largest unit it is a whole multiple of, e.g. "10MB".
+func formatSize(size uint) string {
+	for _, u := range []struct {
+		size ByteSize
+		unit string
+	}{{GB, "GB"}, {MB, "MB"}, {KB, "KB"}} {
+		if size > 0 && size%uint(u.size) == 0 {
+			return fmt.Sprintf("%d%s", size/uint(u.size), u.unit)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordHandler records the lines written to it.
+type recordHandler struct {
+	mutex  sync.Mutex
+	lines  []string
+	closed bool
+	block  chan struct{} // if set, Write waits until it is closed
+}
+
+func (rh *recordHandler) Write(b []byte) (int, error) {
+	if rh.block != nil {
+		<-rh.block
+	}
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	rh.lines = append(rh.lines, string(b))
+	return len(b), nil
+}
+
+func (rh *recordHandler) Close() error {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	rh.closed = true
+	return nil
+}
+
+func (rh *recordHandler) String() string {
+	return "recordHandler"
+}
+
+func (rh *recordHandler) Lines() []string {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	return append([]string(nil), rh.lines...)
+}
+
+func TestAsyncHandlerDeliversAll(t *testing.T) {
+	rh := &recordHandler{}
+	ah := NewAsyncHandler(rh, 10, false)
+
+	buf := make([]byte, 0, 16)
+	for i := 0; i < 100; i++ {
+		// reuse the buffer like log.Logger does
+		buf = append(buf[:0], fmt.Sprintf("line %d", i)...)
+		if _, err := ah.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ah.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := rh.Lines()
+	if len(lines) != 100 {
+		t.Fatalf("Expected 100 lines after Close, got %d", len(lines))
+	}
+	for i, l := range lines {
+		if want := fmt.Sprintf("line %d", i); l != want {
+			t.Fatalf("Expected %q, got %q", want, l)
+		}
+	}
+	if !rh.closed {
+		t.Error("Expected Close to close the wrapped handler")
+	}
+	if _, err := ah.Write([]byte("late")); err == nil {
+		t.Error("Expected an error writing to a closed AsyncHandler")
+	}
+}
+
+func TestAsyncHandlerFlush(t *testing.T) {
+	rh := &recordHandler{block: make(chan struct{})}
+	ah := NewAsyncHandler(rh, 10, false)
+	defer ah.Close()
+
+	for i := 0; i < 5; i++ {
+		ah.Write([]byte("line"))
+	}
+	flushed := make(chan error)
+	go func() { flushed <- ah.Flush() }()
+	select {
+	case <-flushed:
+		t.Fatal("Expected Flush to wait for the queued lines")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(rh.block)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rh.Lines()); n != 5 {
+		t.Errorf("Expected 5 lines after Flush, got %d", n)
+	}
+}
+
+func TestAsyncHandlerDropOnFull(t *testing.T) {
+	rh := &recordHandler{block: make(chan struct{})}
+	ah := NewAsyncHandler(rh, 5, true)
+
+	for i := 0; i < 20; i++ {
+		ah.Write([]byte("line"))
+	}
+	if ah.Dropped() == 0 {
+		t.Error("Expected lines to be dropped while the queue is full")
+	}
+
+	close(rh.block)
+	ah.Close()
+	if got := uint64(len(rh.Lines())) + ah.Dropped(); got != 20 {
+		t.Errorf("Expected delivered + dropped to be 20, got %d", got)
+	}
+}
+
+func TestDiscardHandler(t *testing.T) {
+	dh := NewDiscardHandler()
+	dh.Write([]byte("one\n"))
+	dh.Write([]byte("two\n"))
+	if dh.Writes() != 2 || dh.Bytes() != 8 {
+		t.Errorf("Unexpected counts %d writes, %d bytes", dh.Writes(), dh.Bytes())
+	}
+}
+
+func TestWriterHandlerClose(t *testing.T) {
+	rh := &recordHandler{}
+	wh := NewWriterHandler(rh, "")
+	if wh.String() != "WriterHandler" {
+		t.Errorf("Unexpected name %q", wh.String())
+	}
+	wh.Close()
+	if rh.closed {
+		t.Fatal("Expected Close to leave the writer open")
+	}
+
+	wh.SetCloseWriter(true)
+	wh.Close()
+	if !rh.closed {
+		t.Error("Expected Close to close the writer")
+	}
+}
+
+// errHandler fails to write and close.
+type errHandler struct{}
+
+func (errHandler) Write(b []byte) (int, error) { return 0, errors.New("broken") }
+func (errHandler) Close() error                { return errors.New("broken") }
+func (errHandler) String() string              { return "errHandler" }
+
+func TestMultiHandler(t *testing.T) {
+	first, last := &recordHandler{}, &recordHandler{}
+	mh := NewMultiHandler(first, errHandler{}, last)
+
+	n, err := mh.Write([]byte("line\n"))
+	if err == nil || !strings.Contains(err.Error(), "errHandler: broken") {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+	if n != 5 {
+		t.Errorf("Write returned %d", n)
+	}
+	for _, rh := range []*recordHandler{first, last} {
+		if lines := rh.Lines(); len(lines) != 1 || lines[0] != "line\n" {
+			t.Errorf("Expected each handler to get the line, got %q", lines)
+		}
+	}
+
+	if err := mh.Close(); err == nil {
+		t.Error("Expected the close error")
+	}
+	if !first.closed || !last.closed {
+		t.Error("Expected all handlers to be closed")
+	}
+}
+
+func TestMemoryHandler(t *testing.T) {
+	mh := NewMemoryHandler()
+	mh.Write([]byte("one\n"))
+	mh.Write([]byte("two\nthree\n"))
+	if got := fmt.Sprint(mh.Lines()); got != "[one two three]" {
+		t.Errorf("Unexpected lines %s", got)
+	}
+
+	mh.Reset()
+	if n := len(mh.Lines()); n != 0 {
+		t.Fatalf("Expected no lines after Reset, got %d", n)
+	}
+
+	const writers, lines = 4, 100
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				mh.Write([]byte(fmt.Sprintf("%d %d\n", w, i)))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	next := make([]int, writers)
+	for _, line := range mh.Lines() {
+		var w, i int
+		if _, err := fmt.Sscanf(line, "%d %d", &w, &i); err != nil {
+			t.Fatal(err)
+		}
+		if i != next[w] {
+			t.Fatalf("Line %q out of order, expected %d", line, next[w])
+		}
+		next[w]++
+	}
+	for w, n := range next {
+		if n != lines {
+			t.Errorf("Writer %d: expected %d lines, got %d", w, lines, n)
+		}
+	}
+}
+
+// fakeTB records the Log calls of a TestHandler.
+type fakeTB struct {
+	helpers int
+	logs    []string
+}
+
+func (tb *fakeTB) Helper() { tb.helpers++ }
+
+func (tb *fakeTB) Log(args ...interface{}) { tb.logs = append(tb.logs, fmt.Sprint(args...)) }
+
+func TestTestHandler(t *testing.T) {
+	tb := &fakeTB{}
+	th := NewTestHandler(tb)
+	th.Write([]byte("main  info     one\n"))
+	th.Write([]byte("main  err      two\n"))
+	if err := th.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprintf("%q", tb.logs); got != `["main  info     one" "main  err      two"]` {
+		t.Errorf("Expected one Log call per line, got %s", got)
+	}
+	if tb.helpers != len(tb.logs) {
+		t.Errorf("Expected Helper before each Log call, got %d calls", tb.helpers)
+	}
+
+	// a *testing.T can be passed as is
+	var _ TestLogger = t
+}
+
+func TestHandlerError(t *testing.T) {
+	fh, err := NewFileHandler(filepath.Join(t.TempDir(), "closed.log"), 0, 0, 1, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+
+	_, err = fh.Write([]byte("after close\n"))
+	var he *HandlerError
+	if !errors.As(err, &he) {
+		t.Fatalf("Expected a HandlerError, got %v", err)
+	}
+	if he.Handler != "FileHandler" {
+		t.Errorf("Unexpected handler name %q", he.Handler)
+	}
+	if !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed, got %v", err)
+	}
+
+	ah := NewAsyncHandler(&recordHandler{}, 1, false)
+	ah.Close()
+	if _, err = ah.Write([]byte("after close\n")); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed, got %v", err)
+	}
+}
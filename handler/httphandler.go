@@ -0,0 +1,265 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHTTPTimeout is the request timeout of the default HTTPHandler client.
+const DefaultHTTPTimeout = 10 * time.Second
+
+// HTTPHandler batches log lines and POSTs them to an HTTP collector.
+//
+// A batch is sent when it reaches the batch size or when the flush interval has passed,
+// as newline delimited lines or, with HTTPJSONArray, as a JSON array. A request failing
+// with a 5xx status or a network error is retried as set by the RetryPolicy; a batch
+// that still can't be delivered is dropped and counted. While a batch is being sent,
+// at most HTTPMaxPending lines are buffered, further lines are dropped and counted.
+type HTTPHandler struct {
+	url       string
+	client    *http.Client
+	size      int
+	pending   int // max buffered lines
+	interval  time.Duration
+	jsonArray bool
+	retry     retrier
+	batch     [][]byte
+	dropped   uint64
+	closed    bool
+	kick      chan struct{} // the batch is full
+	done      chan struct{}
+	stopped   chan struct{}
+	mutex     sync.Mutex // guards batch and closed
+	sendMutex sync.Mutex // serializes requests
+}
+
+// HTTPOption configures an HTTPHandler.
+type HTTPOption func(hh *HTTPHandler)
+
+// HTTPBatchSize sets the number of lines sent in one request, 100 by default.
+func HTTPBatchSize(n int) HTTPOption {
+	return func(hh *HTTPHandler) {
+		if n > 0 {
+			hh.size = n
+		}
+	}
+}
+
+// HTTPMaxPending sets the number of lines buffered while the collector is slow or down,
+// 10 times the batch size by default. Further lines are dropped and counted.
+func HTTPMaxPending(n int) HTTPOption {
+	return func(hh *HTTPHandler) {
+		if n > 0 {
+			hh.pending = n
+		}
+	}
+}
+
+// HTTPFlushInterval sets how often buffered lines are sent, 1s by default.
+func HTTPFlushInterval(d time.Duration) HTTPOption {
+	return func(hh *HTTPHandler) {
+		if d > 0 {
+			hh.interval = d
+		}
+	}
+}
+
+// HTTPJSONArray sends each batch as a JSON array. Lines that are JSON objects, e.g. from
+// a JSON formatter, are added as they are and other lines as strings.
+func HTTPJSONArray() HTTPOption {
+	return func(hh *HTTPHandler) {
+		hh.jsonArray = true
+	}
+}
+
+// HTTPRetryPolicy sets how failed requests are retried.
+func HTTPRetryPolicy(p RetryPolicy) HTTPOption {
+	return func(hh *HTTPHandler) {
+		hh.retry.policy = p
+	}
+}
+
+// HTTPClient sets the client used for the requests. By default a client with a
+// DefaultHTTPTimeout timeout is used, so a hanging collector doesn't block Flush and Close.
+func HTTPClient(c *http.Client) HTTPOption {
+	return func(hh *HTTPHandler) {
+		hh.client = c
+	}
+}
+
+// NewHTTPHandler returns a handler posting log lines to url.
+func NewHTTPHandler(url string, opts ...HTTPOption) *HTTPHandler {
+	hh := &HTTPHandler{
+		url:      url,
+		client:   &http.Client{Timeout: DefaultHTTPTimeout},
+		size:     100,
+		interval: time.Second,
+		retry:    retrier{policy: DefaultRetryPolicy},
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(hh)
+	}
+	if hh.pending == 0 {
+		hh.pending = 10 * hh.size
+	}
+	go hh.run()
+	return hh
+}
+
+// Write adds a log message to the batch.
+func (hh *HTTPHandler) Write(b []byte) (n int, err error) {
+	hh.mutex.Lock()
+	if hh.closed {
+		hh.mutex.Unlock()
+		return 0, handlerError(hh, hh.url, ErrHandlerClosed)
+	}
+	if len(hh.batch) >= hh.pending {
+		hh.mutex.Unlock()
+		atomic.AddUint64(&hh.dropped, 1)
+		return len(b), nil
+	}
+	// copy, the caller may reuse b
+	hh.batch = append(hh.batch, append([]byte(nil), bytes.TrimSuffix(b, []byte("\n"))...))
+	full := len(hh.batch) >= hh.size
+	hh.mutex.Unlock()
+
+	if full {
+		select {
+		case hh.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(b), nil
+}
+
+// Flush sends the buffered lines.
+func (hh *HTTPHandler) Flush() error {
+	hh.sendMutex.Lock()
+	defer hh.sendMutex.Unlock()
+
+	hh.mutex.Lock()
+	batch := hh.batch
+	hh.batch = nil
+	hh.mutex.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := hh.post(batch); err != nil {
+		atomic.AddUint64(&hh.dropped, uint64(len(batch)))
+		return handlerError(hh, hh.url, err)
+	}
+	return nil
+}
+
+// Close sends the buffered lines and stops the handler.
+func (hh *HTTPHandler) Close() error {
+	hh.mutex.Lock()
+	if hh.closed {
+		hh.mutex.Unlock()
+		return nil
+	}
+	hh.closed = true
+	hh.mutex.Unlock()
+
+	close(hh.done)
+	<-hh.stopped
+	return hh.Flush()
+}
+
+// String returns the handler name.
+func (hh *HTTPHandler) String() string {
+	return "HTTPHandler"
+}
+
+// Describe returns the collector url.
+func (hh *HTTPHandler) Describe() string {
+	return "http " + hh.url
+}
+
+// Dropped returns the number of lines discarded because they couldn't be sent
+// or too many were buffered.
+func (hh *HTTPHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&hh.dropped)
+}
+
+func (hh *HTTPHandler) run() {
+	defer close(hh.stopped)
+	ticker := time.NewTicker(hh.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-hh.kick:
+		case <-hh.done:
+			return
+		}
+		hh.Flush()
+	}
+}
+
+// post sends the lines, retrying on 5xx responses and network errors.
+func (hh *HTTPHandler) post(lines [][]byte) error {
+	body, contentType := hh.encode(lines)
+	for attempt := 0; ; attempt++ {
+		resp, err := hh.client.Post(hh.url, contentType, bytes.NewReader(body))
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("Unexpected response status %s", resp.Status)
+			if resp.StatusCode < 500 {
+				return err
+			}
+		}
+
+		if attempt >= hh.retry.policy.Retries {
+			return err
+		}
+		hh.retry.wait(attempt)
+	}
+}
+
+// encode returns the request body for the lines and its content type.
+func (hh *HTTPHandler) encode(lines [][]byte) ([]byte, string) {
+	var buf bytes.Buffer
+	if !hh.jsonArray {
+		for _, line := range lines {
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), "application/x-ndjson"
+	}
+
+	buf.WriteByte('[')
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if bytes.HasPrefix(line, []byte("{")) && json.Valid(line) {
+			buf.Write(line)
+		} else {
+			s, _ := json.Marshal(string(line))
+			buf.Write(s)
+		}
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), "application/json"
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector records the request bodies posted to it.
+type collector struct {
+	mutex    sync.Mutex
+	bodies   []string
+	types    []string
+	statuses []int // responses to return in order, then 200
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.statuses) > 0 {
+		status := c.statuses[0]
+		c.statuses = c.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	c.bodies = append(c.bodies, string(b))
+	c.types = append(c.types, r.Header.Get("Content-Type"))
+}
+
+func (c *collector) received() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.bodies...)
+}
+
+// waitBodies waits until n request bodies have been received.
+func (c *collector) waitBodies(t *testing.T, n int) []string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if bodies := c.received(); len(bodies) >= n {
+			return bodies
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d requests, got %q", n, c.received())
+	return nil
+}
+
+func TestHTTPHandlerBatches(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	hh := NewHTTPHandler(srv.URL, HTTPBatchSize(3), HTTPFlushInterval(time.Hour))
+	hh.Write([]byte("one\n"))
+	hh.Write([]byte("two\n"))
+	if bodies := c.received(); len(bodies) != 0 {
+		t.Fatalf("Sent before the batch was full: %q", bodies)
+	}
+	hh.Write([]byte("three\n"))
+	bodies := c.waitBodies(t, 1)
+	if bodies[0] != "one\ntwo\nthree\n" || c.types[0] != "application/x-ndjson" {
+		t.Errorf("Unexpected request %q (%s)", bodies[0], c.types[0])
+	}
+
+	hh.Write([]byte("four\n"))
+	if err := hh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bodies = c.received()
+	if len(bodies) != 2 || bodies[1] != "four\n" {
+		t.Errorf("Expected Close to send the last line, got %q", bodies)
+	}
+	if _, err := hh.Write([]byte("closed\n")); err == nil {
+		t.Error("Expected an error writing to a closed handler")
+	}
+}
+
+func TestHTTPHandlerJSONArray(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	hh := NewHTTPHandler(srv.URL, HTTPJSONArray(), HTTPFlushInterval(10*time.Millisecond))
+	hh.Write([]byte(`{"msg":"json line"}` + "\n"))
+	hh.Write([]byte("text \"line\"\n"))
+	bodies := c.waitBodies(t, 1)
+	hh.Close()
+
+	if want := `[{"msg":"json line"},"text \"line\""]`; bodies[0] != want || c.types[0] != "application/json" {
+		t.Errorf("Unexpected request:\n got  %q (%s)\n want %q", bodies[0], c.types[0], want)
+	}
+}
+
+func TestHTTPHandlerRetry(t *testing.T) {
+	c := &collector{statuses: []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusBadRequest}}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	hh := NewHTTPHandler(srv.URL, HTTPFlushInterval(time.Hour), HTTPRetryPolicy(RetryPolicy{Retries: 1}))
+	defer hh.Close()
+
+	// delivered on the retry
+	hh.Write([]byte("retried\n"))
+	if err := hh.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// 500 twice, dropped
+	hh.Write([]byte("dropped 1\n"))
+	hh.Write([]byte("dropped 2\n"))
+	if err := hh.Flush(); err == nil {
+		t.Error("Expected an error after the retries")
+	}
+	// 4xx is not retried
+	hh.Write([]byte("bad request\n"))
+	if err := hh.Flush(); err == nil {
+		t.Error("Expected an error for a bad request")
+	}
+
+	if bodies := c.received(); len(bodies) != 1 || bodies[0] != "retried\n" {
+		t.Errorf("Unexpected requests %q", bodies)
+	}
+	if n := hh.Dropped(); n != 3 {
+		t.Errorf("Expected 3 dropped lines, got %d", n)
+	}
+}
+
+func TestHTTPHandlerMaxPending(t *testing.T) {
+	release := make(chan struct{})
+	c := &collector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		c.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	hh := NewHTTPHandler(srv.URL, HTTPBatchSize(2), HTTPMaxPending(3), HTTPFlushInterval(time.Hour))
+	if hh.client.Timeout != DefaultHTTPTimeout {
+		t.Errorf("Expected the default client timeout %v, got %v", DefaultHTTPTimeout, hh.client.Timeout)
+	}
+
+	// the first batch is stuck at the collector
+	hh.Write([]byte("1\n"))
+	hh.Write([]byte("2\n"))
+	for i := 0; i < 1000; i++ {
+		hh.mutex.Lock()
+		n := len(hh.batch)
+		hh.mutex.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 3; i <= 6; i++ {
+		hh.Write([]byte(fmt.Sprintf("%d\n", i)))
+	}
+	if n := hh.Dropped(); n != 1 {
+		t.Errorf("Expected 1 dropped line, got %d", n)
+	}
+
+	close(release)
+	hh.Close()
+	if bodies := c.received(); strings.Join(bodies, "") != "1\n2\n3\n4\n5\n" {
+		t.Errorf("Unexpected requests %q", bodies)
+	}
+}
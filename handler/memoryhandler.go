@@ -0,0 +1,63 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"strings"
+	"sync"
+)
+
+// MemoryHandler keeps the log lines in memory, e.g. to assert on the log output
+// in tests of code using the logger.
+type MemoryHandler struct {
+	lines []string
+	mutex sync.Mutex
+}
+
+// NewMemoryHandler returns a handler that stores everything written to it.
+func NewMemoryHandler() *MemoryHandler {
+	return &MemoryHandler{}
+}
+
+// Write stores a log message, one line per newline delimited line in b.
+func (mh *MemoryHandler) Write(b []byte) (n int, err error) {
+	mh.mutex.Lock()
+	defer mh.mutex.Unlock()
+
+	mh.lines = append(mh.lines, strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")...)
+	return len(b), nil
+}
+
+// Close handler.
+func (mh *MemoryHandler) Close() error {
+	return nil
+}
+
+// String returns the handler name.
+func (mh *MemoryHandler) String() string {
+	return "MemoryHandler"
+}
+
+// Describe returns the handler destination.
+func (mh *MemoryHandler) Describe() string {
+	return "memory"
+}
+
+// Lines returns a copy of the stored lines, in the order they were written,
+// without the trailing newlines.
+func (mh *MemoryHandler) Lines() []string {
+	mh.mutex.Lock()
+	defer mh.mutex.Unlock()
+
+	return append([]string(nil), mh.lines...)
+}
+
+// Reset removes the stored lines.
+func (mh *MemoryHandler) Reset() {
+	mh.mutex.Lock()
+	defer mh.mutex.Unlock()
+
+	mh.lines = nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultiHandler groups handlers as one, e.g. to wrap several handlers in a single
+// AsyncHandler. Unlike io.MultiWriter a failing handler does not stop the others.
+type MultiHandler struct {
+	handlers []Handler
+}
+
+// NewMultiHandler returns a handler writing to each of handlers.
+func NewMultiHandler(handlers ...Handler) *MultiHandler {
+	return &MultiHandler{handlers: append([]Handler(nil), handlers...)}
+}
+
+// Write log message to each handler. The errors of the failing handlers are returned together.
+func (mh *MultiHandler) Write(b []byte) (n int, err error) {
+	return len(b), mh.each("write to", func(h Handler) error {
+		n, err := h.Write(b)
+		if err == nil && n < len(b) {
+			err = ErrShortWrite
+		}
+		return err
+	})
+}
+
+// Flush flushes the handlers that are a Flusher.
+func (mh *MultiHandler) Flush() error {
+	return mh.each("flush", Flush)
+}
+
+// Close closes all handlers, also after a failing one.
+func (mh *MultiHandler) Close() error {
+	return mh.each("close", func(h Handler) error { return h.Close() })
+}
+
+// String returns the handler name.
+func (mh *MultiHandler) String() string {
+	return "MultiHandler"
+}
+
+// Describe returns the descriptions of the handlers.
+func (mh *MultiHandler) Describe() string {
+	descs := make([]string, len(mh.handlers))
+	for i, h := range mh.handlers {
+		descs[i] = Describe(h)
+	}
+	return "multi [" + strings.Join(descs, ", ") + "]"
+}
+
+// each calls fn for each handler and returns the errors together.
+func (mh *MultiHandler) each(op string, fn func(h Handler) error) error {
+	var errs []string
+	for _, h := range mh.handlers {
+		if err := fn(h); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", h, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Unable to %s handlers: %s", op, strings.Join(errs, "; "))
+	}
+	return nil
+}
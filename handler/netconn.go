@@ -0,0 +1,115 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"bytes"
+	"net"
+	"sync"
+)
+
+// netConn is a connection to a network address that is redialed after a failed
+// write as set by its RetryPolicy. It is shared by NetHandler and UnixSocketHandler.
+type netConn struct {
+	network string
+	address string
+	framed  bool // each line is sent as a datagram instead of newline delimited
+	conn    net.Conn
+	closed  bool
+	retry   retrier
+	mutex   sync.Mutex
+}
+
+// SetRetryPolicy sets how the handler reconnects after a failed write.
+func (nc *netConn) SetRetryPolicy(p RetryPolicy) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	nc.retry.policy = p
+}
+
+// dial connects to the address.
+func (nc *netConn) dial() (err error) {
+	nc.conn, err = net.Dial(nc.network, nc.address)
+	return err
+}
+
+// write sends b, reconnecting as set by the retry policy. Errors are reported for h.
+func (nc *netConn) write(h Handler, b []byte) (n int, err error) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if nc.closed {
+		return 0, handlerError(h, nc.address, ErrHandlerClosed)
+	}
+	for attempt := 0; ; attempt++ {
+		if nc.conn == nil {
+			err = nc.dial()
+		}
+		if nc.conn != nil {
+			if err = nc.send(b); err == nil {
+				return len(b), nil
+			}
+			nc.conn.Close()
+			nc.conn = nil
+		}
+
+		if attempt >= nc.retry.policy.Retries {
+			return 0, handlerError(h, nc.address, err)
+		}
+		nc.retry.wait(attempt)
+	}
+}
+
+// verify dials the address if the connection was dropped. Nothing is sent.
+// Errors are reported for h.
+func (nc *netConn) verify(h Handler) error {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if nc.closed {
+		return handlerError(h, nc.address, ErrHandlerClosed)
+	}
+	if nc.conn == nil {
+		if err := nc.dial(); err != nil {
+			return handlerError(h, nc.address, err)
+		}
+	}
+	return nil
+}
+
+// close closes the connection, writing afterwards returns ErrHandlerClosed.
+func (nc *netConn) close() error {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	nc.closed = true
+	if nc.conn == nil {
+		return nil
+	}
+	err := nc.conn.Close()
+	nc.conn = nil
+	return err
+}
+
+func (nc *netConn) send(b []byte) error {
+	if nc.framed {
+		for _, line := range bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n")) {
+			if _, err := nc.conn.Write(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b[:len(b):len(b)], '\n')
+	}
+	n, err := nc.conn.Write(b)
+	if err == nil && n < len(b) {
+		err = ErrShortWrite
+	}
+	return err
+}
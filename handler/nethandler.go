@@ -0,0 +1,56 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"strings"
+)
+
+// NetHandler writes log lines as they are to a TCP or UDP address, e.g. a logstash or
+// fluentd input. Over TCP the lines are newline delimited, over UDP each line is sent
+// as a datagram.
+//
+// A failed write is retried on a new connection as set by the RetryPolicy. A TCP
+// connection closed by the peer is only noticed on a write, so a line written right
+// after the peer went away can be lost.
+type NetHandler struct {
+	netConn
+}
+
+// NewNetHandler returns a handler connected to address, network is "tcp" or "udp".
+func NewNetHandler(network, address string) (*NetHandler, error) {
+	nh := &NetHandler{netConn{network: network, address: address, framed: strings.HasPrefix(network, "udp"), retry: retrier{policy: DefaultRetryPolicy}}}
+	if err := nh.dial(); err != nil {
+		return nil, err
+	}
+	return nh, nil
+}
+
+// Write log message.
+func (nh *NetHandler) Write(b []byte) (n int, err error) {
+	return nh.write(nh, b)
+}
+
+// Verify checks that the handler is connected, dialing the address if the connection
+// was dropped. Nothing is sent. A UDP "connection" only fails if the address can't be
+// resolved.
+func (nh *NetHandler) Verify() error {
+	return nh.verify(nh)
+}
+
+// Close handler. Writing afterwards returns ErrHandlerClosed.
+func (nh *NetHandler) Close() error {
+	return nh.close()
+}
+
+// String returns the handler name.
+func (nh *NetHandler) String() string {
+	return "NetHandler"
+}
+
+// Describe returns the network address.
+func (nh *NetHandler) Describe() string {
+	return nh.network + "://" + nh.address
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetHandlerTCP(t *testing.T) {
+	lines := make(chan string, 10)
+	us := listenStream(t, "tcp", "127.0.0.1:0", lines)
+	addr := us.l.Addr().String()
+
+	nh, err := NewNetHandler("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nh.Close()
+	nh.SetRetryPolicy(RetryPolicy{Retries: 1})
+
+	if _, err := nh.Write([]byte("first line\n")); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, lines, "first line")
+
+	// restart the collector, the broken connection is noticed on a write and replaced
+	us.Close()
+	us = listenStream(t, "tcp", addr, lines)
+	defer us.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := nh.Write([]byte("after restart")); err != nil {
+			t.Fatalf("Expected the handler to reconnect: %v", err)
+		}
+		select {
+		case got := <-lines:
+			if got != "after restart" {
+				t.Errorf("Expected %q, got %q", "after restart", got)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("No line delivered after the restart")
+		}
+	}
+}
+
+func TestNetHandlerUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	nh, err := NewNetHandler("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nh.Close()
+
+	if _, err := nh.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	for _, want := range []string{"one", "two"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("Expected datagram %q, got %q", want, buf[:n])
+		}
+	}
+}
+
+func TestNetHandlerClosed(t *testing.T) {
+	lines := make(chan string, 10)
+	us := listenStream(t, "tcp", "127.0.0.1:0", lines)
+	defer us.Close()
+
+	nh, err := NewNetHandler("tcp", us.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nh.Write([]byte("after close\n")); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed, got %v", err)
+	}
+	if nh.conn != nil {
+		t.Error("Reconnected after Close")
+	}
+}
+
+func TestNetHandlerVerify(t *testing.T) {
+	lines := make(chan string, 10)
+	us := listenStream(t, "tcp", "127.0.0.1:0", lines)
+	defer us.Close()
+
+	nh, err := NewNetHandler("tcp", us.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nh.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	// a dropped connection is redialed
+	nh.conn.Close()
+	nh.conn = nil
+	if err := nh.Verify(); err != nil || nh.conn == nil {
+		t.Fatalf("Expected a new connection, got %v", err)
+	}
+	nh.Write([]byte("line\n"))
+	receive(t, lines, "line")
+
+	nh.Close()
+	if err := nh.Verify(); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how network handlers reconnect after a failed write.
+// The delay before reconnect attempt n (counting from 0) is
+// InitialDelay * Multiplier^n, randomly adjusted by up to +/- Jitter of its
+// value and capped at MaxDelay.
+type RetryPolicy struct {
+	Retries      int           // reconnect attempts per write
+	InitialDelay time.Duration // delay before the first reconnect
+	MaxDelay     time.Duration // upper bound of the delay, 0 for none
+	Multiplier   float64       // growth of the delay per attempt, at least 1
+	Jitter       float64       // fraction of the delay to randomize, 0 to 1
+}
+
+// DefaultRetryPolicy is used by network handlers unless another policy is set.
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:      1,
+	InitialDelay: 50 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// Delay returns the delay before the given reconnect attempt.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	return p.delay(attempt, rand.Float64)
+}
+
+func (p RetryPolicy) delay(attempt int, random func() float64) time.Duration {
+	m := p.Multiplier
+	if m < 1 {
+		m = 1
+	}
+	d := float64(p.InitialDelay) * math.Pow(m, float64(attempt))
+	if p.Jitter > 0 {
+		d += d * math.Min(p.Jitter, 1) * (2*random() - 1)
+	}
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	return time.Duration(d)
+}
+
+// retrier waits between reconnect attempts following a RetryPolicy.
+type retrier struct {
+	policy RetryPolicy
+	sleep  func(time.Duration) // time.Sleep if nil
+}
+
+// wait sleeps before the given reconnect attempt.
+func (r *retrier) wait(attempt int) {
+	sleep := r.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	sleep(r.policy.Delay(attempt))
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyJitter(t *testing.T) {
+	p := RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 3, Jitter: 0.5}
+
+	for _, tc := range []struct {
+		attempt  int
+		random   float64
+		expected time.Duration
+	}{
+		{0, 0, 50 * time.Millisecond},
+		{0, 0.5, 100 * time.Millisecond},
+		{1, 0.5, 300 * time.Millisecond},
+		{1, 1, 450 * time.Millisecond},
+		{2, 0, 450 * time.Millisecond},
+		{2, 1, time.Second}, // capped
+		{5, 0, time.Second},
+	} {
+		if d := p.delay(tc.attempt, func() float64 { return tc.random }); d != tc.expected {
+			t.Errorf("Attempt %d with random %v: expected %v, got %v", tc.attempt, tc.random, tc.expected, d)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := p.Delay(1); d < 150*time.Millisecond || d > 450*time.Millisecond {
+			t.Errorf("Delay %v out of the jitter range", d)
+		}
+	}
+}
@@ -5,32 +5,165 @@
 package handler
 
 import (
-	"errors"
+	"bytes"
+	"fmt"
+	"io"
 	"log/syslog"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// maxInflight caps the number of undelivered records kept for resending.
+const maxInflight = 1024
+
+// DefaultSyslogMaxLength is the default maximum length in bytes of a syslog message.
+const DefaultSyslogMaxLength = 1024
+
+// truncatedMarker ends a message that was cut to the maximum length.
+const truncatedMarker = "...[truncated]"
+
 // SyslogHandler writes to syslog.
+//
+// Each line handed to Write is sent as a separate syslog record. Records stay
+// in an in-flight buffer until syslog has accepted them in full, so if the
+// connection drops in the middle of a batch the handler redials, backing off
+// as set by its RetryPolicy, and resends only the records that were not
+// delivered, never a partial one. The handler's lock is not held while it
+// backs off. A handler from NewLazySyslogHandler keeps the records until its
+// first connection is made in the background.
+//
+// Lines written with WriteSeverity are sent with that severity, other lines
+// with the priority the handler was created with.
+//
+// Lines longer than the maximum length, DefaultSyslogMaxLength unless set with
+// SetMaxLength, are truncated and end with "...[truncated]" since syslog daemons
+// drop or cut oversized messages.
 type SyslogHandler struct {
-	Out *syslog.Writer
+	Out      *syslog.Writer
+	protocol string
+	ipaddr   string
+	priority syslog.Priority
+	tag      string
+	tags     map[syslog.Priority]string // tag per severity
+	conns    map[string]io.WriteCloser  // connection per tag, "" is the handler's tag
+	dial     func(tag string) (io.WriteCloser, error)
+	inflight []syslogRecord
+	bufCap   int           // max undelivered records, maxInflight if 0
+	pending  bool          // not yet connected, records are kept until the background dial succeeds
+	stop     chan struct{} // closed by Close to stop the background dial
+	closed   bool          // writing fails once closed
+	maxLen   int           // truncate longer messages, 0 for no limit
+	retry    retrier
+	mutex    sync.Mutex
+}
+
+type syslogRecord struct {
+	tag      string
+	severity syslog.Priority // -1 for the handler's priority
+	b        []byte
 }
 
-// Write log message.
+// Write log message with the handler's priority.
 func (sh *SyslogHandler) Write(b []byte) (n int, err error) {
-	n, err = sh.Out.Write(b)
-	if err != nil {
-		return n, err
+	return sh.write("", -1, b)
+}
+
+// WriteSeverity writes a log message with the given syslog severity and the handler's
+// facility, e.g. so that an err line reaches syslog as LOG_ERR. The message is sent with
+// the tag set for the severity by SetSeverityTag, if any.
+func (sh *SyslogHandler) WriteSeverity(severity syslog.Priority, b []byte) (n int, err error) {
+	severity &= 0x07
+	sh.mutex.Lock()
+	tag := sh.tags[severity]
+	sh.mutex.Unlock()
+
+	return sh.write(tag, severity, b)
+}
+
+// SetSeverityTag sets the tag used for messages of the given syslog severity, e.g. so that
+// syslog can route syslog.LOG_ERR messages differently. An empty tag restores the handler's tag.
+func (sh *SyslogHandler) SetSeverityTag(severity syslog.Priority, tag string) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if sh.tags == nil {
+		sh.tags = make(map[syslog.Priority]string)
+	}
+	if tag == "" {
+		delete(sh.tags, severity&0x07)
+		return
 	}
+	sh.tags[severity&0x07] = tag
+}
+
+// SetMaxLength sets the maximum length in bytes of a message including the truncation
+// marker. 0 disables truncation.
+func (sh *SyslogHandler) SetMaxLength(n int) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
 
-	if n < len(b) {
-		return n, errors.New("Unable to write all bytes to syslog")
+	sh.maxLen = n
+}
+
+// Verify checks that the handler is connected to syslog, dialing it if the connection
+// was dropped. Nothing is sent. A handler from NewLazySyslogHandler that is not connected
+// yet passes, it keeps the lines until it is.
+func (sh *SyslogHandler) Verify() error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if sh.closed {
+		return handlerError(sh, sh.ipaddr, ErrHandlerClosed)
+	}
+	if sh.pending {
+		return nil
+	}
+	if _, err := sh.conn(""); err != nil {
+		return handlerError(sh, sh.ipaddr, err)
 	}
+	return nil
+}
+
+// SetRetryPolicy sets how the handler reconnects to syslog after a failed write.
+func (sh *SyslogHandler) SetRetryPolicy(p RetryPolicy) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
 
-	return n, err
+	sh.retry.policy = p
 }
 
-// Close handler.
+// Close handler. Undelivered records are sent first, as set by the retry policy. The
+// records kept by a handler from NewLazySyslogHandler that is not connected yet are
+// dropped, the error reports how many. Writing afterwards returns ErrHandlerClosed.
 func (sh *SyslogHandler) Close() error {
-	return sh.Out.Close()
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if sh.closed {
+		return nil
+	}
+	var err error
+	if !sh.pending {
+		err = sh.flush()
+	} else if n := len(sh.inflight); n > 0 {
+		err = fmt.Errorf("Unable to send %d lines, syslog is not connected", n)
+	}
+	if err != nil {
+		err = handlerError(sh, sh.ipaddr, err)
+	}
+	sh.inflight = nil
+	sh.closed = true
+	if sh.stop != nil {
+		close(sh.stop)
+	}
+	for tag, c := range sh.conns {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(sh.conns, tag)
+	}
+	return err
 }
 
 // String returns the handler name.
@@ -38,15 +171,233 @@ func (sh *SyslogHandler) String() string {
 	return "SyslogHandler"
 }
 
+// facilityNames are the syslog facility names by facility number.
+var facilityNames = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+	"", "", "", "", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// Describe returns the syslog address and facility, e.g. "syslog udp://host:514 (local0)".
+func (sh *SyslogHandler) Describe() string {
+	dest := "syslog local"
+	if sh.protocol != "" || sh.ipaddr != "" {
+		dest = "syslog " + sh.protocol + "://" + sh.ipaddr
+	}
+	if i := int(sh.priority >> 3); i < len(facilityNames) && facilityNames[i] != "" {
+		return dest + " (" + facilityNames[i] + ")"
+	}
+	return dest
+}
+
 // NewSyslogHandler returns a handler for syslog
 func NewSyslogHandler(protocol, ipaddr string, priority syslog.Priority, tag string) (sh *SyslogHandler, err error) {
-	sh = &SyslogHandler{}
-
-	sh.Out, err = syslog.Dial(protocol, ipaddr, priority, tag)
+	sh = newSyslogHandler(protocol, ipaddr, priority, tag)
+	c, err := sh.dial("")
 	if err != nil {
 		return nil, err
 	}
+	sh.setConn("", c)
 
 	return sh, nil
 }
 
+// NewLazySyslogHandler returns a handler for syslog that does not fail if syslog is not
+// up yet, e.g. while the system is starting. Until connected, syslog is dialed every
+// retryInterval in the background and the lines are kept, at most bufCap of them
+// dropping the oldest, and sent once connected. A bufCap of 0 keeps up to 1024 lines.
+// Syslog is dialed without holding the handler's lock, so a slow dial does not block
+// Write or Close. Lines still kept when the handler is closed are dropped, see Close.
+func NewLazySyslogHandler(protocol, ipaddr string, priority syslog.Priority, tag string, retryInterval time.Duration, bufCap int) *SyslogHandler {
+	sh := newSyslogHandler(protocol, ipaddr, priority, tag)
+	sh.bufCap = bufCap
+	sh.pending = true
+	sh.stop = make(chan struct{})
+	go sh.connect(retryInterval)
+	return sh
+}
+
+// connect dials syslog every interval until connected or closed and then sends the kept lines.
+func (sh *SyslogHandler) connect(interval time.Duration) {
+	for {
+		// dial without the lock, lines are kept meanwhile
+		c, err := sh.dial("")
+		if err == nil {
+			sh.mutex.Lock()
+			defer sh.mutex.Unlock()
+
+			if sh.closed {
+				c.Close()
+				return
+			}
+			sh.setConn("", c)
+			sh.pending = false
+			// undelivered lines are kept for the next write
+			sh.flush()
+			return
+		}
+
+		select {
+		case <-sh.stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func newSyslogHandler(protocol, ipaddr string, priority syslog.Priority, tag string) *SyslogHandler {
+	sh := &SyslogHandler{protocol: protocol, ipaddr: ipaddr, priority: priority, tag: tag, conns: make(map[string]io.WriteCloser),
+		maxLen: DefaultSyslogMaxLength}
+	sh.retry.policy = DefaultRetryPolicy
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		if tag == "" {
+			tag = sh.tag
+		}
+		w, err := syslog.Dial(sh.protocol, sh.ipaddr, sh.priority, tag)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	return sh
+}
+
+func (sh *SyslogHandler) write(tag string, severity syslog.Priority, b []byte) (n int, err error) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if sh.closed {
+		return 0, handlerError(sh, sh.ipaddr, ErrHandlerClosed)
+	}
+	sh.queue(tag, severity, b)
+	if sh.pending {
+		return len(b), nil
+	}
+	if err = sh.flush(); err != nil {
+		return 0, handlerError(sh, sh.ipaddr, err)
+	}
+	return len(b), nil
+}
+
+// queue splits b into one record per line and appends them to the in-flight buffer.
+func (sh *SyslogHandler) queue(tag string, severity syslog.Priority, b []byte) {
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		// copy, the caller may reuse b
+		sh.inflight = append(sh.inflight, syslogRecord{tag, severity, truncate(line, sh.maxLen)})
+	}
+
+	limit := maxInflight
+	if sh.bufCap > 0 {
+		limit = sh.bufCap
+	}
+	if over := len(sh.inflight) - limit; over > 0 {
+		sh.inflight = sh.inflight[over:]
+	}
+}
+
+// truncate returns a copy of line cut to max bytes, ending with the truncation marker,
+// if it is longer. It does not cut within a UTF-8 encoded character.
+func truncate(line []byte, max int) []byte {
+	if max <= 0 || len(line) <= max {
+		return append([]byte(nil), line...)
+	}
+	if max <= len(truncatedMarker) {
+		return []byte(truncatedMarker[:max])
+	}
+	i := max - len(truncatedMarker)
+	for i > 0 && !utf8.RuneStart(line[i]) {
+		i--
+	}
+	return append(append([]byte(nil), line[:i]...), truncatedMarker...)
+}
+
+// flush writes the in-flight records in order. A record is only dropped from
+// the buffer once it has been written in full. On failure the connection is
+// redialed as set by the retry policy and sending resumes with the first
+// undelivered record. The caller must hold the lock, which is released while
+// backing off.
+func (sh *SyslogHandler) flush() error {
+	attempt := 0
+	for len(sh.inflight) > 0 {
+		if sh.closed {
+			return ErrHandlerClosed
+		}
+		rec := sh.inflight[0]
+		w, err := sh.conn(rec.tag)
+		if err == nil {
+			if err = writeRecord(w, rec); err != nil {
+				w.Close()
+				delete(sh.conns, rec.tag)
+			}
+		}
+
+		if err != nil {
+			if attempt >= sh.retry.policy.Retries {
+				return err
+			}
+			retry := sh.retry
+			sh.mutex.Unlock()
+			retry.wait(attempt)
+			sh.mutex.Lock()
+			attempt++
+			continue
+		}
+		sh.inflight = sh.inflight[1:]
+	}
+	return nil
+}
+
+// writeRecord writes rec to w, with the record's severity if it has one and w is a syslog.Writer.
+func writeRecord(w io.WriteCloser, rec syslogRecord) error {
+	if sw, ok := w.(*syslog.Writer); ok && rec.severity >= 0 {
+		m := string(rec.b)
+		switch rec.severity {
+		case syslog.LOG_EMERG:
+			return sw.Emerg(m)
+		case syslog.LOG_ALERT:
+			return sw.Alert(m)
+		case syslog.LOG_CRIT:
+			return sw.Crit(m)
+		case syslog.LOG_ERR:
+			return sw.Err(m)
+		case syslog.LOG_WARNING:
+			return sw.Warning(m)
+		case syslog.LOG_NOTICE:
+			return sw.Notice(m)
+		case syslog.LOG_INFO:
+			return sw.Info(m)
+		case syslog.LOG_DEBUG:
+			return sw.Debug(m)
+		}
+	}
+
+	n, err := w.Write(rec.b)
+	if err == nil && n < len(rec.b) {
+		err = ErrShortWrite
+	}
+	return err
+}
+
+// conn returns the connection for tag, dialing it if needed unless the handler is closed.
+func (sh *SyslogHandler) conn(tag string) (io.WriteCloser, error) {
+	if sh.closed {
+		return nil, ErrHandlerClosed
+	}
+	if c, ok := sh.conns[tag]; ok {
+		return c, nil
+	}
+	c, err := sh.dial(tag)
+	if err != nil {
+		return nil, err
+	}
+	sh.setConn(tag, c)
+	return c, nil
+}
+
+// setConn sets the connection for tag, and Out for the handler's tag.
+func (sh *SyslogHandler) setConn(tag string, c io.WriteCloser) {
+	sh.conns[tag] = c
+	if w, ok := c.(*syslog.Writer); ok && tag == "" {
+		sh.Out = w
+	}
+}
@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSyslog records whole records and drops the connection after limit writes.
+type fakeSyslog struct {
+	recv  *[]string
+	limit int
+	n     int
+}
+
+func (fs *fakeSyslog) Write(b []byte) (int, error) {
+	if fs.limit >= 0 && fs.n >= fs.limit {
+		return 0, errors.New("connection reset by peer")
+	}
+	fs.n++
+	*fs.recv = append(*fs.recv, string(b))
+	return len(b), nil
+}
+
+func (fs *fakeSyslog) Close() error {
+	return nil
+}
+
+func TestSyslogHandlerReconnectMidBatch(t *testing.T) {
+	var recv []string
+	dials := 0
+	sh := &SyslogHandler{retry: retrier{policy: RetryPolicy{Retries: 1}}}
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		dials++
+		return &fakeSyslog{recv: &recv, limit: -1}, nil
+	}
+	// the first connection drops after 3 records
+	sh.conns = map[string]io.WriteCloser{"": &fakeSyslog{recv: &recv, limit: 3}}
+
+	var batch []string
+	for i := 0; i < 10; i++ {
+		batch = append(batch, fmt.Sprintf("record %d", i))
+	}
+
+	n, err := sh.Write([]byte(strings.Join(batch, "\n") + "\n"))
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n != len(strings.Join(batch, "\n"))+1 {
+		t.Errorf("Write returned %d", n)
+	}
+	if dials != 1 {
+		t.Errorf("Expected 1 redial, got %d", dials)
+	}
+	if strings.Join(recv, "|") != strings.Join(batch, "|") {
+		t.Errorf("Records split or lost after reconnect:\n got  %q\n want %q", recv, batch)
+	}
+}
+
+func TestSyslogHandlerKeepsUndelivered(t *testing.T) {
+	var recv []string
+	sh := &SyslogHandler{retry: retrier{policy: RetryPolicy{Retries: 1}}}
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		return nil, errors.New("connection refused")
+	}
+	sh.conns = map[string]io.WriteCloser{"": &fakeSyslog{recv: &recv, limit: 1}}
+
+	if _, err := sh.Write([]byte("first\nsecond\n")); err == nil {
+		t.Fatal("Expected an error while syslog is down")
+	}
+
+	// syslog is back, the undelivered record is sent before the new one
+	sh.conns[""] = &fakeSyslog{recv: &recv, limit: -1}
+	if _, err := sh.Write([]byte("third\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got := strings.Join(recv, "|"); got != "first|second|third" {
+		t.Errorf("Unexpected records: %q", got)
+	}
+}
+
+func TestSyslogHandlerClosed(t *testing.T) {
+	var recv []string
+	dials := 0
+	sh := &SyslogHandler{retry: retrier{policy: RetryPolicy{Retries: 1}}}
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		dials++
+		return &fakeSyslog{recv: &recv, limit: -1}, nil
+	}
+	sh.conns = map[string]io.WriteCloser{"": &fakeSyslog{recv: &recv, limit: -1}}
+
+	if err := sh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sh.Write([]byte("after close\n")); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed, got %v", err)
+	}
+	if _, err := sh.WriteSeverity(syslog.LOG_ERR, []byte("after close\n")); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed, got %v", err)
+	}
+	if dials != 0 || len(recv) != 0 {
+		t.Errorf("Redialed %d times after Close, sent %q", dials, recv)
+	}
+}
+
+func TestSyslogHandlerVerify(t *testing.T) {
+	var recv []string
+	dials := 0
+	sh := &SyslogHandler{conns: map[string]io.WriteCloser{}, retry: retrier{policy: RetryPolicy{Retries: 1}}}
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		dials++
+		return &fakeSyslog{recv: &recv, limit: -1}, nil
+	}
+
+	if err := sh.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 1 || len(recv) != 0 {
+		t.Errorf("Expected a dial and nothing sent, got %d dials and %q", dials, recv)
+	}
+
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		return nil, errors.New("connection refused")
+	}
+	delete(sh.conns, "")
+	if err := sh.Verify(); err == nil {
+		t.Error("Expected an error while syslog is down")
+	}
+}
+
+func TestSyslogHandlerTruncate(t *testing.T) {
+	var recv []string
+	sh := &SyslogHandler{conns: map[string]io.WriteCloser{"": &fakeSyslog{recv: &recv, limit: -1}}}
+	sh.SetMaxLength(DefaultSyslogMaxLength)
+
+	long := strings.Repeat("x", 4096)
+	if _, err := sh.Write([]byte("short\n" + long + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if len(recv) != 2 || recv[0] != "short" {
+		t.Fatalf("Unexpected records %q", recv)
+	}
+	if len(recv[1]) != DefaultSyslogMaxLength || !strings.HasSuffix(recv[1], truncatedMarker) {
+		t.Errorf("Expected a %d byte record ending with %q, got %d bytes ending with %q",
+			DefaultSyslogMaxLength, truncatedMarker, len(recv[1]), recv[1][len(recv[1])-20:])
+	}
+
+	// multibyte characters are not cut
+	recv = nil
+	sh.SetMaxLength(20)
+	sh.Write([]byte(strings.Repeat("å", 20)))
+	if want := "ååå" + truncatedMarker; recv[0] != want {
+		t.Errorf("Expected %q, got %q", want, recv[0])
+	}
+}
+
+// listenSyslog receives syslog messages on a local UDP socket.
+func listenSyslog(t *testing.T) (addr string, msgs <-chan string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			c <- string(buf[:n])
+		}
+	}()
+	return conn.LocalAddr().String(), c
+}
+
+func receiveSyslog(t *testing.T, msgs <-chan string) string {
+	t.Helper()
+	select {
+	case m := <-msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a syslog message")
+	}
+	return ""
+}
+
+func TestSyslogHandlerSeverityTag(t *testing.T) {
+	addr, msgs := listenSyslog(t)
+	sh, err := NewSyslogHandler("udp", addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	sh.SetSeverityTag(syslog.LOG_ERR, "app-err")
+
+	sh.WriteSeverity(syslog.LOG_ERR, []byte("disk failure\n"))
+	if m := receiveSyslog(t, msgs); !strings.Contains(m, " app-err[") || !strings.HasSuffix(m, "disk failure\n") {
+		t.Errorf("Expected the err tag: %q", m)
+	}
+
+	sh.WriteSeverity(syslog.LOG_INFO, []byte("all good\n"))
+	if m := receiveSyslog(t, msgs); !strings.Contains(m, " app[") {
+		t.Errorf("Expected the handler tag: %q", m)
+	}
+
+	sh.Write([]byte("plain write\n"))
+	if m := receiveSyslog(t, msgs); !strings.Contains(m, " app[") {
+		t.Errorf("Expected the handler tag: %q", m)
+	}
+}
+
+func TestSyslogHandlerBackoff(t *testing.T) {
+	var delays []time.Duration
+	sh := &SyslogHandler{conns: map[string]io.WriteCloser{}}
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		return nil, errors.New("connection refused")
+	}
+	sh.retry = retrier{
+		policy: RetryPolicy{Retries: 5, InitialDelay: 100 * time.Millisecond, MaxDelay: 500 * time.Millisecond, Multiplier: 2},
+		sleep:  func(d time.Duration) { delays = append(delays, d) },
+	}
+
+	if _, err := sh.Write([]byte("lost\n")); err == nil {
+		t.Fatal("Expected an error while syslog is down")
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}
+	if fmt.Sprint(delays) != fmt.Sprint(want) {
+		t.Errorf("Unexpected delays:\n got  %v\n want %v", delays, want)
+	}
+}
+
+func TestLazySyslogHandler(t *testing.T) {
+	// a free port for syslog to come up on later
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	sh := NewLazySyslogHandler("tcp", addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "app", 10*time.Millisecond, 2)
+	defer sh.Close()
+	for _, m := range []string{"dropped", "kept 1", "kept 2"} {
+		if _, err := sh.Write([]byte(m + "\n")); err != nil {
+			t.Fatalf("Expected no error while syslog is down, got %v", err)
+		}
+	}
+
+	lines := make(chan string, 10)
+	us := listenStream(t, "tcp", addr, lines)
+	defer us.Close()
+	for _, want := range []string{"kept 1", "kept 2"} {
+		if m := receiveSyslog(t, lines); !strings.HasSuffix(m, want) {
+			t.Errorf("Expected %q, got %q", want, m)
+		}
+	}
+
+	sh.Write([]byte("connected\n"))
+	if m := receiveSyslog(t, lines); !strings.HasSuffix(m, "connected") {
+		t.Errorf("Unexpected message %q", m)
+	}
+}
+
+func TestLazySyslogHandlerSlowDial(t *testing.T) {
+	var recv []string
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	sh := newSyslogHandler("tcp", "syslog:514", syslog.LOG_INFO, "app")
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		close(dialing)
+		<-release
+		return &fakeSyslog{recv: &recv, limit: -1}, nil
+	}
+	sh.pending = true
+	sh.stop = make(chan struct{})
+	go sh.connect(time.Hour)
+	<-dialing
+
+	done := make(chan error)
+	go func() {
+		if _, err := sh.Write([]byte("kept\n")); err != nil {
+			done <- err
+			return
+		}
+		done <- sh.Close()
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "Unable to send 1 lines") {
+			t.Errorf("Expected Close to report the dropped line, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write or Close blocked by the background dial")
+	}
+	close(release)
+}
+
+func TestSyslogHandlerCloseFlushes(t *testing.T) {
+	var recv []string
+	sh := &SyslogHandler{} // no retries
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		return &fakeSyslog{recv: &recv, limit: -1}, nil
+	}
+	sh.conns = map[string]io.WriteCloser{"": &fakeSyslog{recv: &recv, limit: 1}}
+
+	if _, err := sh.Write([]byte("first\nsecond\n")); err == nil {
+		t.Fatal("Expected an error for the dropped connection")
+	}
+	if err := sh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(recv, "|"); got != "first|second" {
+		t.Errorf("Expected Close to send the undelivered record, got %q", got)
+	}
+}
+
+func TestSyslogHandlerBackoffUnlocked(t *testing.T) {
+	sh := &SyslogHandler{conns: map[string]io.WriteCloser{}}
+	sh.dial = func(tag string) (io.WriteCloser, error) {
+		return nil, errors.New("connection refused")
+	}
+	sh.retry = retrier{
+		policy: RetryPolicy{Retries: 1},
+		sleep: func(time.Duration) {
+			done := make(chan struct{})
+			go func() {
+				sh.SetMaxLength(100)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Error("The lock is held while backing off")
+			}
+		},
+	}
+	sh.Write([]byte("lost\n"))
+}
+
+func TestSyslogHandlerTCPReconnect(t *testing.T) {
+	lines := make(chan string, 10)
+	us := listenStream(t, "tcp", "127.0.0.1:0", lines)
+	addr := us.l.Addr().String()
+
+	sh, err := NewSyslogHandler("tcp", addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	sh.SetRetryPolicy(RetryPolicy{Retries: 1})
+
+	sh.Write([]byte("before restart\n"))
+	if m := receiveSyslog(t, lines); !strings.HasSuffix(m, "before restart") {
+		t.Errorf("Unexpected message %q", m)
+	}
+
+	// syslog goes away, writes fail or are lost until it is back
+	us.Close()
+	sh.Write([]byte("while down\n"))
+	us = listenStream(t, "tcp", addr, lines)
+	defer us.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sh.Write([]byte("after restart\n"))
+		select {
+		case m := <-lines:
+			if strings.HasSuffix(m, "after restart") {
+				return
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("No message delivered after syslog came back")
+		}
+	}
+}
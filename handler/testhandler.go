@@ -0,0 +1,54 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"strings"
+)
+
+// TestLogger is the part of testing.TB used by TestHandler, so that the package does not
+// import testing. A *testing.T or *testing.B is a TestLogger.
+type TestLogger interface {
+	Helper()
+	Log(args ...interface{})
+}
+
+// TestHandler writes each log message with the Log method of a test, so that the
+// output is attributed to the test and only shown if it fails or with go test -v.
+type TestHandler struct {
+	tb TestLogger
+}
+
+// NewTestHandler returns a handler logging to the test tb.
+// Remove it before the test ends, a test must not log after it has completed.
+//
+//	th := handler.NewTestHandler(t)
+//	lg.AddHandler(th)
+//	defer lg.RemoveHandler(th)
+func NewTestHandler(tb TestLogger) *TestHandler {
+	return &TestHandler{tb: tb}
+}
+
+// Write log message, one Log call per message without the trailing newline.
+func (th *TestHandler) Write(b []byte) (n int, err error) {
+	th.tb.Helper()
+	th.tb.Log(strings.TrimSuffix(string(b), "\n"))
+	return len(b), nil
+}
+
+// Close handler.
+func (th *TestHandler) Close() error {
+	return nil
+}
+
+// String returns the handler name.
+func (th *TestHandler) String() string {
+	return "TestHandler"
+}
+
+// Describe returns the handler destination.
+func (th *TestHandler) Describe() string {
+	return "test log"
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+// UnixSocketHandler writes to a unix domain socket, e.g. a local log aggregator.
+//
+// A framed handler uses a unixgram socket and sends each line as a datagram.
+// Otherwise newline delimited lines are written to a unix stream socket.
+// A failed write is retried on a new connection as set by the RetryPolicy.
+type UnixSocketHandler struct {
+	netConn
+}
+
+// NewUnixSocketHandler returns a handler connected to the unix socket at path.
+func NewUnixSocketHandler(path string, framed bool) (*UnixSocketHandler, error) {
+	network := "unix"
+	if framed {
+		network = "unixgram"
+	}
+	uh := &UnixSocketHandler{netConn{network: network, address: path, framed: framed, retry: retrier{policy: DefaultRetryPolicy}}}
+	if err := uh.dial(); err != nil {
+		return nil, err
+	}
+	return uh, nil
+}
+
+// Write log message.
+func (uh *UnixSocketHandler) Write(b []byte) (n int, err error) {
+	return uh.write(uh, b)
+}
+
+// Verify checks that the handler is connected, dialing the socket if the connection
+// was dropped. Nothing is sent.
+func (uh *UnixSocketHandler) Verify() error {
+	return uh.verify(uh)
+}
+
+// Close handler. Writing afterwards returns ErrHandlerClosed.
+func (uh *UnixSocketHandler) Close() error {
+	return uh.close()
+}
+
+// String returns the handler name.
+func (uh *UnixSocketHandler) String() string {
+	return "UnixSocketHandler"
+}
+
+// Describe returns the socket path.
+func (uh *UnixSocketHandler) Describe() string {
+	return uh.network + " " + uh.address
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unixServer accepts connections on a stream socket and sends each received line to lines.
+type unixServer struct {
+	l     net.Listener
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func listenUnix(t *testing.T, path string, lines chan<- string) *unixServer {
+	return listenStream(t, "unix", path, lines)
+}
+
+func listenStream(t *testing.T, network, address string, lines chan<- string) *unixServer {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &unixServer{l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			us.mutex.Lock()
+			us.conns = append(us.conns, conn)
+			us.mutex.Unlock()
+			go func() {
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+	return us
+}
+
+// Close stops listening and drops all connections.
+func (us *unixServer) Close() {
+	us.l.Close()
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	for _, c := range us.conns {
+		c.Close()
+	}
+}
+
+func receive(t *testing.T, lines <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-lines:
+		if got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %q", want)
+	}
+}
+
+func TestUnixSocketHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	lines := make(chan string, 10)
+	us := listenUnix(t, path, lines)
+
+	uh, err := NewUnixSocketHandler(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uh.Close()
+
+	if _, err := uh.Write([]byte("first line\n")); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, lines, "first line")
+
+	// restart the aggregator, the handler reconnects on the next write
+	us.Close()
+	us = listenUnix(t, path, lines)
+	defer us.Close()
+
+	if _, err := uh.Write([]byte("after restart")); err != nil {
+		t.Fatalf("Expected the handler to reconnect: %v", err)
+	}
+	receive(t, lines, "after restart")
+}
+
+func TestUnixSocketHandlerClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	us := listenUnix(t, path, make(chan string, 10))
+	defer us.Close()
+
+	uh, err := NewUnixSocketHandler(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uh.Write([]byte("after close\n")); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Expected ErrHandlerClosed, got %v", err)
+	}
+	if uh.conn != nil {
+		t.Error("Reconnected after Close")
+	}
+}
+
+func TestUnixSocketHandlerVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	lines := make(chan string, 10)
+	us := listenUnix(t, path, lines)
+	defer us.Close()
+
+	uh, err := NewUnixSocketHandler(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uh.Close()
+	if err := uh.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	uh.Write([]byte("line\n"))
+	// the probe sent nothing, the first line received is the written one
+	receive(t, lines, "line")
+
+	us.Close()
+	os.Remove(path)
+	uh.conn.Close()
+	uh.conn = nil
+	if err := uh.Verify(); err == nil {
+		t.Error("Expected an error without a listener")
+	}
+}
+
+func TestUnixSocketHandlerFramed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	uh, err := NewUnixSocketHandler(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uh.Close()
+
+	if _, err := uh.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	for _, want := range []string{"one", "two"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("Expected datagram %q, got %q", want, buf[:n])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package handler
+
+import (
+	"io"
+	"sync"
+)
+
+// WriterHandler adapts an io.Writer, e.g. a bytes.Buffer or an *os.File managed by
+// the caller, to the Handler interface. Writes are serialized.
+type WriterHandler struct {
+	w      io.Writer
+	name   string
+	closeW bool
+	mutex  sync.Mutex
+}
+
+// NewWriterHandler returns a handler writing to w and named name. Close does not
+// close w unless SetCloseWriter is set.
+func NewWriterHandler(w io.Writer, name string) *WriterHandler {
+	if name == "" {
+		name = "WriterHandler"
+	}
+	return &WriterHandler{w: w, name: name}
+}
+
+// Write log message.
+func (wh *WriterHandler) Write(b []byte) (n int, err error) {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	return wh.w.Write(b)
+}
+
+// SetCloseWriter sets whether Close closes the writer if it is an io.Closer.
+func (wh *WriterHandler) SetCloseWriter(close bool) {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	wh.closeW = close
+}
+
+// Flush flushes the writer if it has a Flush() error method, e.g. a bufio.Writer.
+func (wh *WriterHandler) Flush() error {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	if f, ok := wh.w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close handler.
+func (wh *WriterHandler) Close() error {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+
+	if c, ok := wh.w.(io.Closer); ok && wh.closeW {
+		return c.Close()
+	}
+	return nil
+}
+
+// String returns the handler name.
+func (wh *WriterHandler) String() string {
+	return wh.name
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader is the header HTTPMiddleware reads and sets the request ID with.
+const RequestIDHeader = "X-Request-Id"
+
+// HTTPSeverity is the default mapping from a response status code to the severity
+// used by HTTPRequest: 5xx is logged as err, 4xx as warning and everything else as info.
+func HTTPSeverity(status int) SeverityFilter {
+	switch {
+	case status >= 500:
+		return ErrSeverity
+	case status >= 400:
+		return WarningSeverity
+	default:
+		return InfoSeverity
+	}
+}
+
+// SetHTTPSeverity sets the function HTTPRequest uses to map a status code to a severity.
+// A nil function restores the default HTTPSeverity mapping.
+func (l *Logger4go) SetHTTPSeverity(fn func(status int) SeverityFilter) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.httpSeverity = fn
+}
+
+// SetHTTPRequestID sets whether HTTPMiddleware propagates a request ID. It is off by default.
+func (l *Logger4go) SetHTTPRequestID(enabled bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.httpReqID = enabled
+}
+
+// HTTPRequest logs a served HTTP request with the method, path, status and duration as fields.
+// The severity is derived from the status code, see SetHTTPSeverity.
+// Any additional fields are added to the line as well.
+func (l *Logger4go) HTTPRequest(method, path string, status int, dur time.Duration, fields ...Fields) {
+	l.mutex.Lock()
+	severity := l.httpSeverity
+	l.mutex.Unlock()
+	if severity == nil {
+		severity = HTTPSeverity
+	}
+
+	f := mergeFields(fields...)
+	f["method"] = method
+	f["path"] = path
+	f["status"] = status
+	f["duration"] = dur
+	l.logFields(severity(status), f, "HTTP request")
+}
+
+// HTTPMiddleware returns a net/http middleware that logs every request with HTTPRequest.
+// The wrapped handler gets a request context carrying l, see FromContext.
+//
+// With SetHTTPRequestID enabled, the request ID is taken from the X-Request-Id header,
+// or generated if missing, and echoed in the response. The request context carries
+// the request ID as well, so FromContext(r.Context()).InfoCtx(r.Context(), ...) logs
+// with the same request_id field as the request line.
+func HTTPMiddleware(l *Logger4go) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := now()
+			l.mutex.Lock()
+			propagate := l.httpReqID
+			l.mutex.Unlock()
+
+			ctx := r.Context()
+			var fields []Fields
+			if propagate {
+				id := r.Header.Get(RequestIDHeader)
+				if id == "" {
+					id = newRequestID()
+				}
+				w.Header().Set(RequestIDHeader, id)
+				ctx = ContextWithRequestID(ctx, id)
+				fields = append(fields, Fields{"request_id": id})
+			}
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(NewContext(ctx, l)))
+
+			l.HTTPRequest(r.Method, r.URL.Path, sw.status, now().Sub(start), fields...)
+		})
+	}
+}
+
+// statusWriter records the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, if the wrapped writer supports it.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		sw.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, if the wrapped writer supports it.
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,172 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPRequest(t *testing.T) {
+	lg := Get("http")
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	lg.HTTPRequest("GET", "/index.html", 500, 1500*time.Millisecond, Fields{"remote": "10.0.0.1"})
+	line := buf.String()
+	if !strings.Contains(line, ErrString) {
+		t.Errorf("Expected a 500 to be logged at err: %q", line)
+	}
+	for _, f := range []string{"method=GET", "path=/index.html", "status=500", "duration=1.5s", "remote=10.0.0.1"} {
+		if !strings.Contains(line, f) {
+			t.Errorf("Missing field %s in %q", f, line)
+		}
+	}
+
+	buf.Reset()
+	lg.HTTPRequest("GET", "/missing", 404, time.Millisecond)
+	if !strings.Contains(buf.String(), WarningString) {
+		t.Errorf("Expected a 404 to be logged at warning: %q", buf.String())
+	}
+
+	buf.Reset()
+	lg.SetHTTPSeverity(func(status int) SeverityFilter { return DebugSeverity })
+	lg.HTTPRequest("GET", "/index.html", 500, time.Millisecond)
+	if !strings.Contains(buf.String(), DebugString) {
+		t.Errorf("Expected the custom mapping to log at debug: %q", buf.String())
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	lg := Get("http-middleware")
+	lg.SetHTTPRequestID(true)
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	h := HTTPMiddleware(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).InfoCtx(r.Context(), "handling request")
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest("GET", "/tea", nil)
+	req.Header.Set(RequestIDHeader, "abc123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get(RequestIDHeader) != "abc123" {
+		t.Errorf("Expected the request ID to be echoed, got %q", rec.Header().Get(RequestIDHeader))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", lines)
+	}
+	if !strings.Contains(lines[0], "handling request request_id=abc123") {
+		t.Errorf("Expected the context logger to add the request ID: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "status=418") || !strings.Contains(lines[1], "request_id=abc123") {
+		t.Errorf("Unexpected request line: %q", lines[1])
+	}
+
+	i := strings.Index(lines[1], "duration=")
+	if i < 0 {
+		t.Fatalf("Missing duration in %q", lines[1])
+	}
+	dur, err := time.ParseDuration(strings.Fields(lines[1][i+len("duration="):])[0])
+	if err != nil || dur < 10*time.Millisecond || dur > time.Second {
+		t.Errorf("Unexpected duration %v (%v)", dur, err)
+	}
+}
+
+func TestHTTPMiddlewareNoRequestID(t *testing.T) {
+	lg := Get("http-middleware-noid")
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+
+	h := HTTPMiddleware(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).InfoCtx(r.Context(), "handling request")
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "abc123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get(RequestIDHeader) != "" {
+		t.Errorf("Unexpected request ID in the response: %q", rec.Header().Get(RequestIDHeader))
+	}
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("Unexpected request_id: %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "handling request") || !strings.Contains(buf.String(), "status=200") {
+		t.Errorf("Unexpected lines: %q", buf.String())
+	}
+}
+
+func TestHTTPMiddlewareClock(t *testing.T) {
+	var mu sync.Mutex
+	tick := fixedClock()
+	SetClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		tick = tick.Add(250 * time.Millisecond)
+		return tick
+	})
+	defer SetClock(nil)
+
+	lg := Get("http-middleware-clock")
+	var buf bytes.Buffer
+	lg.SetOutput(&buf)
+	lg.SetFlags(0)
+
+	h := HTTPMiddleware(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(buf.String(), "duration=250ms") {
+		t.Errorf("Expected the duration from the package clock, got %q", buf.String())
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (hr *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hr.hijacked = true
+	return nil, nil, nil
+}
+
+func TestHTTPMiddlewareFlushHijack(t *testing.T) {
+	lg := Get("http-middleware-iface")
+	lg.SetOutput(io.Discard)
+
+	rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h := HTTPMiddleware(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("Expected the writer to implement http.Flusher")
+		}
+		f.Flush()
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Fatal("Expected the writer to implement http.Hijacker")
+		}
+		if _, _, err := hj.Hijack(); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if !rec.Flushed {
+		t.Error("Expected the flush to reach the response writer")
+	}
+	if !rec.hijacked {
+		t.Error("Expected the hijack to reach the response writer")
+	}
+}
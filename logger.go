@@ -4,7 +4,7 @@
 
 // Package logger provides Logger4go which is a simple wrapper around go's log.Logger.
 //
-// There are four log handlers StdoutHandler, StderrHandler, FileHandler and SyslogHandler.
+// There are five log handlers StdoutHandler, StderrHandler, FileHandler, SyslogHandler and UnixSocketHandler.
 // A handler writes a log event/line to a specified destination, for example a file or stdout.
 // Logger4go exposes log methods named after syslog's severity levels and also embedds 
 // log.Logger to provide seemless access to its methods as well if needed.
@@ -15,6 +15,7 @@
 //  - Using more than one logger instance. Each with its own set of handler.
 //  - Rotate the log file based on size, per day or number of rotated files with compression.
 //  - Enable only specific severity levels to be written out.
+//  - Text or JSON output with key/value fields.
 //
 // Example output:
 // 	main 2013/06/21 08:21:44.680513  info  init called
@@ -32,10 +33,6 @@
 // 	main 2013/06/21 08:22:14  crit     A critical message
 // 	main 2013/06/21 08:22:14  alert    An alert message
 // 	main 2013/06/21 08:22:14  emerge   An Emergeency message
-//
-// TODO:
-//  - Structured logging support. Output format should be JSON
-//  - Read settings from config file or env vars
 package logger
 
 import (
@@ -43,54 +40,166 @@ import (
 	"io"
 	"log"
 	"log/syslog"
-	"sync"
+	"os"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/alyu/logger/handler"
 )
 
+// ByteSize is the log file size type of the handler package, so that logger.MB and
+// handler.MB can be used interchangeably.
+type ByteSize = handler.ByteSize
+
+// Log file size constants, the same as in the handler package.
+const (
+	KB = handler.KB
+	MB = handler.MB
+	GB = handler.GB
+	TB = handler.TB
+	PB = handler.PB
+	EB = handler.EB
+	ZB = handler.ZB
+	YB = handler.YB
+)
+
 // Logger4go embedds go's log.Logger as an anonymous field and
 // so those methods are also exposed/accessable via Logger4go.
 type Logger4go struct {
-	name     string
-	handlers []handler.Handler
-	filter   SeverityFilter
-	mutex    sync.Mutex
+	name         string
+	handlers     []handler.Handler
+	filter       int32 // SeverityFilter, accessed atomically
+	disabled     int32 // 1 if all lines are dropped, accessed atomically
+	mutex        sync.Mutex
+	httpSeverity func(status int) SeverityFilter // status code to severity for HTTPRequest
+	httpReqID    bool                            // HTTPMiddleware propagates the request ID
+	formatter    Formatter
+	numericLevel bool                         // add level_num to each line
+	static       []string                     // key/value pairs added to each line, replaced on change
+	onWriteError func(handler.Handler, error) // receives handler write errors, nil to ignore them
+	verifyOnAdd  bool                         // verify handlers before adding them
+	mw           io.Writer                    // writes to the handlers
+	out          io.Writer                    // output of the embedded log.Logger, mw or set with SetOutput
+	lazyDefaults bool                         // "main" or "err", InitDefaults is called on the first write
+	routes       map[route]Formatter          // formatter per handler and severity, replaced on change
+	sampler      *sampler                     // collapses repeated messages, nil for none
+	limits       atomic.Value                 // map[SeverityFilter]*tokenBucket, rate limit per severity, replaced on change
+	dropped      uint64                       // lines dropped by the rate limits, accessed atomically
+	outMutex     sync.Mutex                   // serializes writes to the output
 	*log.Logger
 }
 
-// Logger provides a default Logger4go instance that outputs to the console
-var Logger *Logger4go
-
-func init() {
-	// Use stdout handler as default
-	Logger = Get("main")
-	Logger.AddStdoutHandler()
+// Logger provides a default Logger4go instance that outputs to the console. It has no
+// handlers until InitDefaults is called, which the package log functions, Def, Stdout
+// and Stderr do on first use, and so does the first line logged to the "main" or "err"
+// logger, e.g. with Logger.Info. Importing the package has no other side effects.
+var Logger = Get("main")
+
+var defaultsOnce sync.Once
+
+// InitDefaults adds a stdout handler to the default logger "main" and a stderr handler
+// to the "err" logger. It is called on first use of the package log functions, Def,
+// Stdout and Stderr and on the first line logged to "main" or "err", and only adds the
+// handlers once.
+func InitDefaults() {
+	defaultsOnce.Do(func() {
+		Logger.AddStdoutHandler()
+		Get("err").AddStderrHandler()
+	})
+}
 
-	// Add default stderr handler
-	Get("err").AddStderrHandler()
+// def returns the default logger with its handlers.
+func def() *Logger4go {
+	InitDefaults()
+	return Logger
 }
 
 // Def returns the default logger instance with a console handler with no prefix.
 func Def() *Logger4go {
-	return Logger
+	return def()
 }
 
 // Stdout returns a standard logger instance with a stdout console handler using prefix 'main'.
 func Stdout() *Logger4go {
+	InitDefaults()
 	return Get("main")
 }
 
 // Stderr returns the standard logger instance with a stderr console handler using prefix 'err'
 func Stderr() *Logger4go {
+	InitDefaults()
 	return Get("err")
 }
 
+// CloseAll closes the handlers of all loggers and clears the logger registry, e.g. at
+// shutdown to flush and release files and connections. Loggers still in use keep working
+// but have no handlers. All handlers are closed even if some fail and the errors are
+// returned together.
+func CloseAll() error {
+	mu.Lock()
+	loggers := loggers4go
+	loggers4go = make(map[string]*Logger4go)
+	mu.Unlock()
+
+	var errs []string
+	closed := make(map[handler.Handler]bool)
+	for _, l := range loggers {
+		l.flushSampler()
+		l.mutex.Lock()
+		handlers := l.handlers
+		l.handlers = nil
+		l.mutex.Unlock()
+
+		for _, h := range handlers {
+			if closed[h] {
+				continue
+			}
+			closed[h] = true
+			if err := h.Close(); err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v", h, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("Unable to close handlers: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Flush writes out the lines pending in the logger's handlers, e.g. queued by an
+// AsyncHandler, without closing them. Handlers that are not a handler.Flusher write
+// synchronously and are skipped. All handlers are flushed even if some fail and the
+// errors are returned together.
+func (l *Logger4go) Flush() error {
+	l.flushSampler()
+
+	var errs []string
+	for _, h := range l.Handlers() {
+		if err := handler.Flush(h); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", h, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Unable to flush handlers: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 // SeverityFilter represents a severity level to filter
 // go:generate stringer -type=SeverityFilter
 type SeverityFilter int
 
-// severity levels
+// severity levels, ordered from the most severe, EmergSeverity, to the least severe, TraceSeverity.
+// Each level is a single bit so levels can be combined into a filter. TraceSeverity is for
+// output more verbose than debug, e.g. wire-level tracing.
 const (
 	EmergSeverity SeverityFilter = 1 << iota
 	AlertSeverity
@@ -100,20 +209,22 @@ const (
 	NoticeSeverity
 	InfoSeverity
 	DebugSeverity
-	AllSeverity = EmergSeverity | AlertSeverity | CritSeverity | ErrSeverity | WarningSeverity | NoticeSeverity | InfoSeverity | DebugSeverity
+	TraceSeverity
+	AllSeverity = EmergSeverity | AlertSeverity | CritSeverity | ErrSeverity | WarningSeverity | NoticeSeverity | InfoSeverity | DebugSeverity | TraceSeverity
 )
 
-// severity keywords
+// severity names as returned by String. The text formatter pads them to align the columns.
 const (
-	EmergString   = " emerg   "
-	AlertString   = " alert   "
-	CritString    = " crit    "
-	ErrString     = " err     "
-	WarningString = " warning "
-	NoticeString  = " notice  "
-	InfoString    = " info    "
-	DebugString   = " debug   "
-	AllString     = ""
+	EmergString   = "emerg"
+	AlertString   = "alert"
+	CritString    = "crit"
+	ErrString     = "err"
+	WarningString = "warning"
+	NoticeString  = "notice"
+	InfoString    = "info"
+	DebugString   = "debug"
+	TraceString   = "trace"
+	AllString     = "all"
 )
 
 func (s SeverityFilter) String() string {
@@ -134,13 +245,184 @@ func (s SeverityFilter) String() string {
 		return InfoString
 	case s == DebugSeverity:
 		return DebugString
+	case s == TraceSeverity:
+		return TraceString
 	case s == AllSeverity:
 		return AllString
 	default:
+		if name := customName(s); name != "" {
+			return name
+		}
 		return "SeverityFilter(" + strconv.FormatInt(int64(s), 10) + ")"
 	}
 }
 
+var severityNames = map[string]SeverityFilter{
+	"emerg":   EmergSeverity,
+	"alert":   AlertSeverity,
+	"crit":    CritSeverity,
+	"err":     ErrSeverity,
+	"warning": WarningSeverity,
+	"warn":    WarningSeverity,
+	"notice":  NoticeSeverity,
+	"info":    InfoSeverity,
+	"debug":   DebugSeverity,
+	"trace":   TraceSeverity,
+	"all":     AllSeverity,
+}
+
+// ParseSeverity returns the severity with the given name, e.g. "info", "ERR" or "warning".
+// Names are case insensitive and surrounding spaces are ignored so padded names from text
+// output parse as well. "warn" is accepted for warning and "all" for AllSeverity.
+func ParseSeverity(name string) (SeverityFilter, error) {
+	customMutex.RLock()
+	f, ok := severityNames[strings.ToLower(strings.TrimSpace(name))]
+	customMutex.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("Unknown severity %q", name)
+	}
+	return f, nil
+}
+
+// ParseSeverityList parses a comma separated list of severity names, e.g. "err,crit,alert",
+// and returns the combined filter.
+func ParseSeverityList(names string) (SeverityFilter, error) {
+	var filter SeverityFilter
+	for _, name := range strings.Split(names, ",") {
+		f, err := ParseSeverity(name)
+		if err != nil {
+			return 0, err
+		}
+		filter |= f
+	}
+	return filter, nil
+}
+
+// severityOrder lists the levels from the most to the least severe. Level comparisons
+// use this order rather than the bit values, so a new level only needs to be inserted here.
+var severityOrder = []SeverityFilter{
+	EmergSeverity,
+	AlertSeverity,
+	CritSeverity,
+	ErrSeverity,
+	WarningSeverity,
+	NoticeSeverity,
+	InfoSeverity,
+	DebugSeverity,
+	TraceSeverity,
+}
+
+// SeverityAtLeast returns a filter with the given level and all levels more severe than it,
+// e.g. SeverityAtLeast(WarningSeverity) is Warning|Err|Crit|Alert|Emerg.
+// If more than one level is given the least severe of them is used.
+func SeverityAtLeast(level SeverityFilter) SeverityFilter {
+	order := severities()
+	least := -1
+	for i, s := range order {
+		if level&s != 0 {
+			least = i
+		}
+	}
+
+	var filter SeverityFilter
+	for _, s := range order[:least+1] {
+		filter |= s
+	}
+	return filter
+}
+
+// Clone returns a new logger named newName with the handlers and configuration of l: the
+// filter, flags, formatter, routes, static fields, numeric level, sampling, rate limits,
+// HTTP settings, verification and write error callback, e.g. to log with a different
+// prefix or filter to the same destinations. The clone gets the prefix of newName and is
+// registered under it. An error is returned if a logger named newName exists.
+//
+// The handlers are shared, not duplicated: both loggers write to the same files and
+// connections, and closing a handler through either logger closes it for both. Handlers
+// added to or removed from one logger afterwards do not change the other. The sampler and
+// the rate limits start out empty for the clone.
+func (l *Logger4go) Clone(newName string) (*Logger4go, error) {
+	prefix := newName + " "
+	if newName == "" {
+		prefix = ""
+	}
+	c := newLogger(&handler.NoopHandler{}, newName, prefix, l.Flags())
+	c.filter = int32(l.Filter())
+	c.disabled = atomic.LoadInt32(&l.disabled)
+
+	l.mutex.Lock()
+	c.handlers = append([]handler.Handler(nil), l.handlers...)
+	c.formatter = l.formatter
+	c.httpSeverity = l.httpSeverity
+	c.httpReqID = l.httpReqID
+	c.numericLevel = l.numericLevel
+	c.static = l.static // replaced on change
+	c.routes = l.routes // replaced on change
+	c.onWriteError = l.onWriteError
+	c.verifyOnAdd = l.verifyOnAdd
+	if l.sampler != nil {
+		c.sampler = &sampler{l: c, window: l.sampler.window, counts: make(map[sampleKey]int)}
+	}
+	if limits := l.rateLimits(); limits != nil {
+		cl := make(map[SeverityFilter]*tokenBucket, len(limits))
+		for s, tb := range limits {
+			cl[s] = newTokenBucket(int(tb.rate), now())
+		}
+		c.limits.Store(cl)
+	}
+	l.mutex.Unlock()
+	if len(c.handlers) > 0 {
+		c.out = c.mw
+		c.Logger.SetOutput(c.mw)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := loggers4go[newName]; ok {
+		return nil, fmt.Errorf("Unable to clone %s: logger %q exists", l.name, newName)
+	}
+	loggers4go[newName] = c
+	return c, nil
+}
+
+// List returns the sorted names of the loggers created with Get or GetWithFlags.
+func List() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(loggers4go))
+	for name := range loggers4go {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetGlobalFilter sets the severity filter of all registered loggers, e.g. to switch every
+// logger to debug while investigating a live problem. Loggers created later get the default filter.
+func SetGlobalFilter(f SeverityFilter) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, l := range loggers4go {
+		l.SetFilter(f)
+	}
+}
+
+// SetFilterByName sets the severity filter of the named logger. It returns an error
+// if no logger has been created with the name.
+func SetFilterByName(name string, f SeverityFilter) error {
+	mu.RLock()
+	l, ok := loggers4go[name]
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("Unknown logger %q", name)
+	}
+
+	l.SetFilter(f)
+	return nil
+}
+
 // Get returns a logger with the specified name and default log header flags.
 // If it does not exist a new instance will be created.
 func Get(name string) *Logger4go {
@@ -150,36 +432,115 @@ func Get(name string) *Logger4go {
 // GetWithFlags returns a logger with the specified name and log header flags.
 // If it does exist a new instance will be created.
 func GetWithFlags(name string, flags int) *Logger4go {
+	return GetWithOptions(name, WithFlags(flags))
+}
+
+// Option configures a logger created by GetWithOptions.
+type Option func(o *options)
+
+type options struct {
+	prefix *string
+	flags  int
+}
+
+// WithPrefix sets the prefix of each line, which is the name followed by a space by default.
+// An empty prefix gives lines without the name.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = &prefix
+	}
+}
+
+// WithFlags sets the log header flags, log.LstdFlags by default.
+func WithFlags(flags int) Option {
+	return func(o *options) {
+		o.flags = flags
+	}
+}
+
+// GetWithOptions returns a logger with the specified name. If it does not exist a new
+// instance is created with the options, so that the name the logger is registered under
+// and the prefix of its lines can differ, e.g. a bare logger named "svc":
+//
+//	lg := logger.GetWithOptions("svc", logger.WithPrefix(""))
+//
+// The options are ignored if the logger exists, use SetPrefix and SetFlags to change it.
+func GetWithOptions(name string, opts ...Option) *Logger4go {
 	mu.RLock()
 	lg, ok := loggers4go[name]
 	mu.RUnlock()
-	if !ok {
-		prefix := name + " "
-		if name == "" {
-			prefix = ""
-		}
-		lg = newLogger(&handler.NoopHandler{}, name, prefix, flags)
-		// create with a noop writer/handler
-		lg.filter = AllSeverity
-		mu.Lock()
-		defer mu.Unlock()
-		loggers4go[name] = lg
+	if ok {
+		return lg
 	}
+
+	o := options{flags: log.LstdFlags}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	prefix := name + " "
+	if name == "" {
+		prefix = ""
+	}
+	if o.prefix != nil {
+		prefix = *o.prefix
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lg, ok := loggers4go[name]; ok {
+		return lg
+	}
+	// create with a noop writer/handler
+	lg = newLogger(&handler.NoopHandler{}, name, prefix, o.flags)
+	lg.filter = int32(AllSeverity)
+	if name == "main" || name == "err" {
+		// the default handlers are added on the first write
+		lg.lazyDefaults = true
+		lg.out = lg.mw
+		lg.Logger.SetOutput(lg.mw)
+	}
+	loggers4go[name] = lg
 	return lg
 }
 
-// AddStdoutHandler adds a logger that writes to stdout/console
+// AddStdoutHandler adds a logger that writes to stdout/console.
+// An error is returned if stdout is closed or, with SetVerifyOnAdd, not writable.
 func (l *Logger4go) AddStdoutHandler() (sh *handler.StdoutHandler, err error) {
-	sh = &handler.StdoutHandler{}
-	registerHandler(l, sh)
+	sh, err = handler.NewStdoutHandler()
+	if err != nil {
+		return nil, err
+	}
+	if err = registerHandler(l, sh); err != nil {
+		return nil, err
+	}
 
 	return sh, nil
 }
 
-// AddStderrHandler adds a logger that writes to stderr/console
+// AddColorStdoutHandler adds a handler that writes to stdout with the severities colored
+// if stdout is a terminal and NO_COLOR is not set, see handler.ColorStdoutHandler.
+func (l *Logger4go) AddColorStdoutHandler(opts ...handler.ColorOption) (ch *handler.ColorStdoutHandler, err error) {
+	ch, err = handler.NewColorStdoutHandler(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = registerHandler(l, ch); err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// AddStderrHandler adds a logger that writes to stderr/console.
+// An error is returned if stderr is closed or, with SetVerifyOnAdd, not writable.
 func (l *Logger4go) AddStderrHandler() (sh *handler.StderrHandler, err error) {
-	sh = &handler.StderrHandler{}
-	registerHandler(l, sh)
+	sh, err = handler.NewStderrHandler()
+	if err != nil {
+		return nil, err
+	}
+	if err = registerHandler(l, sh); err != nil {
+		return nil, err
+	}
 
 	return sh, nil
 }
@@ -192,24 +553,41 @@ func (l *Logger4go) AddStdFileHandler(filePath string) (fh *handler.FileHandler,
 	if err != nil {
 		return nil, err
 	}
-	registerHandler(l, fh)
+	if err = registerHandler(l, fh); err != nil {
+		fh.Close()
+		return nil, err
+	}
 	return fh, nil
 }
 
 // AddFileHandler adds a file handler with a specified max filesize, max number of rotations, file compression and daily rotation
 func (l *Logger4go) AddFileHandler(filePath string, maxFileSize uint, maxRotation byte, isCompressFile, isDailyRotation bool) (fh *handler.FileHandler, err error) {
+	return l.AddFileHandlerWithSeq(filePath, maxFileSize, maxRotation, 1, isCompressFile, isDailyRotation)
+}
 
-	fh, err = handler.NewFileHandler(filePath, maxFileSize, maxRotation, 1, isCompressFile, isDailyRotation)
+// AddFileHandlerWithSeq adds a file handler like AddFileHandler whose first rotated log file
+// gets the sequence no startSeq, e.g. to continue from where the previous run left off.
+// The first free sequence no from startSeq is used, wrapping around after maxRotation;
+// if all rotated log files exist the oldest one is replaced. A startSeq of 0 or above
+// maxRotation starts from 1.
+func (l *Logger4go) AddFileHandlerWithSeq(filePath string, maxFileSize uint, maxRotation, startSeq byte, isCompressFile, isDailyRotation bool) (fh *handler.FileHandler, err error) {
+	fh, err = handler.NewFileHandler(filePath, maxFileSize, maxRotation, startSeq, isCompressFile, isDailyRotation)
 	if err != nil {
 		return nil, err
 	}
-	registerHandler(l, fh)
+	if err = registerHandler(l, fh); err != nil {
+		fh.Close()
+		return nil, err
+	}
 	return fh, nil
 }
 
 // AddSyslogHandler adds a syslog handler with the specified network procotol tcp|udp, a syslog daemon ip address,
 // a log/syslog priority flag (syslog severity + facility, see syslog godoc) and a tag/prefix.
 // The syslog daemon on localhost will be used if protocol and ipaddr is "".
+// Log lines are sent with the syslog severity matching their level, e.g. LOG_ERR for Err,
+// and the facility of priority. The severity of priority is used for lines written without
+// a level, e.g. with Print.
 //
 // AddSyslogHandler returns a SyslogHandler which can be used to directly access the SyslogHandler.out (syslog.Writer) instance
 // which can be used to write messages with a specific syslog severity and bypassing what the logger instance is set to use.
@@ -219,14 +597,104 @@ func (l *Logger4go) AddSyslogHandler(protocol, ipaddr string, priority syslog.Pr
 	if err != nil {
 		return nil, err
 	}
-	registerHandler(l, sh)
+	if err = registerHandler(l, sh); err != nil {
+		sh.Close()
+		return nil, err
+	}
 
 	return sh, err
 }
 
+// AddSyslogHandlerLazy adds a syslog handler like AddSyslogHandler that does not fail if
+// syslog is not up yet. Syslog is dialed every retryInterval in the background and up to
+// bufCap lines are kept until connected, see handler.NewLazySyslogHandler.
+func (l *Logger4go) AddSyslogHandlerLazy(protocol, ipaddr string, priority syslog.Priority, tag string, retryInterval time.Duration, bufCap int) (sh *handler.SyslogHandler, err error) {
+	sh = handler.NewLazySyslogHandler(protocol, ipaddr, priority, tag, retryInterval, bufCap)
+	if err = registerHandler(l, sh); err != nil {
+		sh.Close()
+		return nil, err
+	}
+	return sh, nil
+}
+
+// AddUnixSocketHandler adds a handler that writes to the unix domain socket at path, e.g. a local log aggregator.
+// If framed is true each line is sent as a datagram on a unixgram socket, otherwise as a newline
+// delimited line on a unix stream socket.
+func (l *Logger4go) AddUnixSocketHandler(path string, framed bool) (uh *handler.UnixSocketHandler, err error) {
+	uh, err = handler.NewUnixSocketHandler(path, framed)
+	if err != nil {
+		return nil, err
+	}
+	if err = registerHandler(l, uh); err != nil {
+		uh.Close()
+		return nil, err
+	}
+
+	return uh, nil
+}
+
+// AddNetHandler adds a handler that writes the log lines as they are to a TCP or UDP address,
+// e.g. a logstash or fluentd input.
+func (l *Logger4go) AddNetHandler(network, address string) (nh *handler.NetHandler, err error) {
+	nh, err = handler.NewNetHandler(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err = registerHandler(l, nh); err != nil {
+		nh.Close()
+		return nil, err
+	}
+
+	return nh, nil
+}
+
 // AddHandler adds a custom handler which conforms to the Handler interface.
-func (l *Logger4go) AddHandler(handler handler.Handler) {
-	registerHandler(l, handler)
+// An error is only returned if the handler fails verification, see SetVerifyOnAdd.
+func (l *Logger4go) AddHandler(handler handler.Handler) error {
+	return registerHandler(l, handler)
+}
+
+// Describe returns where the logger writes, e.g.
+// "stdout; file /var/log/app.log (max 10MB, 5 rotations, gzip); syslog udp://host:514 (local0)".
+func (l *Logger4go) Describe() string {
+	var dests []string
+	for _, h := range l.Handlers() {
+		dests = append(dests, handler.Describe(h))
+	}
+	return strings.Join(dests, "; ")
+}
+
+// LogStartupBanner writes one info line summarizing the logger configuration, the filter
+// and the destinations, so operators can confirm it at startup, e.g.
+//
+//	main 2013/06/21 08:22:14  info     Logger started destinations="stdout; file /var/log/app.log (max 10MB, 5 rotations)" filter=emerg,alert,crit,err,warning,notice,info
+//
+// Like any info line it is only written if the filter includes info.
+func (l *Logger4go) LogStartupBanner() {
+	l.logFields(InfoSeverity, Fields{"filter": filterNames(l.Filter()), "destinations": l.Describe()}, "Logger started")
+}
+
+// filterNames returns the severities in f as a comma separated list, as parsed by ParseSeverityList.
+func filterNames(f SeverityFilter) string {
+	var names []string
+	for _, s := range severities() {
+		if f&s != 0 {
+			names = append(names, s.String())
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// SetVerifyOnAdd sets whether handlers are verified to be writable when they are added,
+// so that e.g. permission problems are reported by the Add method at startup rather than lost
+// on the first log line. Handlers implementing handler.Verifier verify themselves,
+// e.g. a FileHandler checks that the log file opens for writing. Other handlers are
+// not verified, nothing is written to them.
+func (l *Logger4go) SetVerifyOnAdd(verify bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.verifyOnAdd = verify
 }
 
 // RemoveHandler removes the handler from the logger.
@@ -236,15 +704,20 @@ func (l *Logger4go) RemoveHandler(handler handler.Handler) {
 
 	for i, h := range l.handlers {
 		if h == handler {
-			l.handlers = append(l.handlers[:i], l.handlers[i+1:]...)
+			// don't modify in place, output may be iterating over the old list
+			handlers := l.handlers[:0:0]
+			l.handlers = append(append(handlers, l.handlers[:i]...), l.handlers[i+1:]...)
 			break
 		}
 	}
 }
 
-// Handlers returns a list of registered handlers
+// Handlers returns a copy of the list of registered handlers
 func (l *Logger4go) Handlers() []handler.Handler {
-	return l.handlers
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]handler.Handler(nil), l.handlers...)
 }
 
 // Emergf log
@@ -259,12 +732,12 @@ func (l *Logger4go) Emerg(v ...interface{}) {
 
 // Emergf log
 func Emergf(format string, v ...interface{}) {
-	Logger.Emergf(format, v)
+	def().doPrintf(EmergSeverity, format, v...)
 }
 
 // Emerg log
 func Emerg(v ...interface{}) {
-	Logger.Emerg(v)
+	def().doPrintf(EmergSeverity, "%s", v...)
 }
 
 // Alertf log
@@ -279,12 +752,12 @@ func (l *Logger4go) Alert(v ...interface{}) {
 
 // Alertf log
 func Alertf(format string, v ...interface{}) {
-	Logger.Alertf(format, v)
+	def().doPrintf(AlertSeverity, format, v...)
 }
 
 // Alert log
 func Alert(v ...interface{}) {
-	Logger.Alert(v)
+	def().doPrintf(AlertSeverity, "%s", v...)
 }
 
 // Critf log
@@ -299,12 +772,12 @@ func (l *Logger4go) Crit(v ...interface{}) {
 
 // Critf log
 func Critf(format string, v ...interface{}) {
-	Logger.Critf(format, v)
+	def().doPrintf(CritSeverity, format, v...)
 }
 
 // Crit log
 func Crit(v ...interface{}) {
-	Logger.Crit(v)
+	def().doPrintf(CritSeverity, "%s", v...)
 }
 
 // Errf log
@@ -319,12 +792,12 @@ func (l *Logger4go) Err(v ...interface{}) {
 
 // Errf log
 func Errf(format string, v ...interface{}) {
-	Logger.Errf(format, v)
+	def().doPrintf(ErrSeverity, format, v...)
 }
 
 // Err log
 func Err(v ...interface{}) {
-	Logger.Err(v)
+	def().doPrintf(ErrSeverity, "%s", v...)
 }
 
 // Warningf log
@@ -339,12 +812,12 @@ func (l *Logger4go) Warning(v ...interface{}) {
 
 // Warningf log
 func Warningf(format string, v ...interface{}) {
-	Logger.Warningf(format, v)
+	def().doPrintf(WarningSeverity, format, v...)
 }
 
 // Warning log
 func Warning(v ...interface{}) {
-	Logger.Warning(v)
+	def().doPrintf(WarningSeverity, "%s", v...)
 }
 
 // Warnf log
@@ -359,12 +832,12 @@ func (l *Logger4go) Warn(v ...interface{}) {
 
 // Warnf log
 func Warnf(format string, v ...interface{}) {
-	Logger.Warnf(format, v)
+	def().doPrintf(WarningSeverity, format, v...)
 }
 
 //Warn log
 func Warn(v ...interface{}) {
-	Logger.Warn(v)
+	def().doPrintf(WarningSeverity, "%s", v...)
 }
 
 // Noticef log
@@ -379,12 +852,12 @@ func (l *Logger4go) Notice(v ...interface{}) {
 
 // Noticef log
 func Noticef(format string, v ...interface{}) {
-	Logger.Noticef(format, v)
+	def().doPrintf(NoticeSeverity, format, v...)
 }
 
 // Notice log
 func Notice(v ...interface{}) {
-	Logger.Notice(v)
+	def().doPrintf(NoticeSeverity, "%s", v...)
 }
 
 // Infof log
@@ -399,12 +872,12 @@ func (l *Logger4go) Info(v ...interface{}) {
 
 // Infof log
 func Infof(format string, v ...interface{}) {
-	Logger.Infof(format, v)
+	def().doPrintf(InfoSeverity, format, v...)
 }
 
 // Info log
 func Info(v ...interface{}) {
-	Logger.Info(v)
+	def().doPrintf(InfoSeverity, "%s", v...)
 }
 
 // Debugf log
@@ -417,27 +890,299 @@ func (l *Logger4go) Debug(v ...interface{}) {
 	l.doPrintf(DebugSeverity, "%s", v...)
 }
 
+// Tracef log
+func (l *Logger4go) Tracef(format string, v ...interface{}) {
+	l.doPrintf(TraceSeverity, format, v...)
+}
+
+// Trace log
+func (l *Logger4go) Trace(v ...interface{}) {
+	l.doPrintf(TraceSeverity, "%s", v...)
+}
+
 // Debugf log
 func Debugf(format string, v ...interface{}) {
-	Logger.Debugf(format, v)
+	def().doPrintf(DebugSeverity, format, v...)
 }
 
 // Debug log
 func Debug(v ...interface{}) {
-	Logger.Debug(v)
+	def().doPrintf(DebugSeverity, "%s", v...)
+}
+
+// Tracef log
+func Tracef(format string, v ...interface{}) {
+	def().doPrintf(TraceSeverity, format, v...)
+}
+
+// Trace log
+func Trace(v ...interface{}) {
+	def().doPrintf(TraceSeverity, "%s", v...)
+}
+
+// Fatalf logs with crit severity, closes all handlers so that buffered lines are written out
+// and exits with status 1.
+func (l *Logger4go) Fatalf(format string, v ...interface{}) {
+	l.doPrintf(CritSeverity, format, v...)
+	l.fatal()
+}
+
+// Fatal logs with crit severity, closes all handlers so that buffered lines are written out
+// and exits with status 1.
+func (l *Logger4go) Fatal(v ...interface{}) {
+	l.doPrintf(CritSeverity, "%s", v...)
+	l.fatal()
+}
+
+// Fatalf log and exit
+func Fatalf(format string, v ...interface{}) {
+	def().doPrintf(CritSeverity, format, v...)
+	Logger.fatal()
+}
+
+// Fatal log and exit
+func Fatal(v ...interface{}) {
+	def().doPrintf(CritSeverity, "%s", v...)
+	Logger.fatal()
+}
+
+// Panicf logs with crit severity and panics with the message.
+func (l *Logger4go) Panicf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	l.doPrintf(CritSeverity, "%s", msg)
+	panic(msg)
+}
+
+// Panic logs with crit severity and panics with the message.
+func (l *Logger4go) Panic(v ...interface{}) {
+	msg := fmt.Sprintf("%s", v...)
+	l.doPrintf(CritSeverity, "%s", msg)
+	panic(msg)
+}
+
+// Panicf log and panic
+func Panicf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	def().doPrintf(CritSeverity, "%s", msg)
+	panic(msg)
+}
+
+// Panic log and panic
+func Panic(v ...interface{}) {
+	msg := fmt.Sprintf("%s", v...)
+	def().doPrintf(CritSeverity, "%s", msg)
+	panic(msg)
 }
 
 // IsFilterSet returns true if the severity filter is set
 func (l *Logger4go) IsFilterSet(f SeverityFilter) bool {
-	return f&l.filter == f
+	return f&l.Filter() == f
+}
+
+// Enabled returns true if lines of the given level are written, i.e. the logger is enabled
+// and its filter is set for level. Use it to skip building expensive log arguments:
+//
+//	if lg.Enabled(logger.DebugSeverity) {
+//		lg.Debugf("state %s", dump(state))
+//	}
+//
+// Lines over a rate limit are still dropped.
+func (l *Logger4go) Enabled(level SeverityFilter) bool {
+	return l.IsEnabled() && l.IsFilterSet(level)
+}
+
+// Filter returns the severity filter
+func (l *Logger4go) Filter() SeverityFilter {
+	return SeverityFilter(atomic.LoadInt32(&l.filter))
 }
 
 // SetFilter sets a severity filter
 func (l *Logger4go) SetFilter(f SeverityFilter) {
+	atomic.StoreInt32(&l.filter, int32(f))
+}
+
+// Disable drops all lines until the logger is enabled again. The handlers and the
+// filter are kept.
+func (l *Logger4go) Disable() {
+	atomic.StoreInt32(&l.disabled, 1)
+}
+
+// Enable resumes logging after Disable.
+func (l *Logger4go) Enable() {
+	atomic.StoreInt32(&l.disabled, 0)
+}
+
+// IsEnabled returns false if the logger has been disabled.
+func (l *Logger4go) IsEnabled() bool {
+	return atomic.LoadInt32(&l.disabled) == 0
+}
+
+// SetMinLevel sets the filter to the given level and all levels more severe than it.
+func (l *Logger4go) SetMinLevel(level SeverityFilter) {
+	l.SetFilter(SeverityAtLeast(level))
+}
+
+// Formatter returns the formatter used to render log lines.
+func (l *Logger4go) Formatter() Formatter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.formatter == nil {
+		return &TextFormatter{}
+	}
+	return l.formatter
+}
+
+// SetFormatter sets the formatter used to render log lines, e.g. &JSONFormatter{}.
+// A nil formatter restores the default TextFormatter.
+func (l *Logger4go) SetFormatter(f Formatter) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.formatter = f
+}
+
+// RouteFormat sets the formatter used for lines of the given severity levels written to h,
+// e.g. to write info lines as text and err lines as JSON to the same file. Other handlers
+// and levels use the logger's formatter. A nil formatter removes the route.
+func (l *Logger4go) RouteFormat(level SeverityFilter, h handler.Handler, f Formatter) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// copy, output may be reading the current routes
+	routes := make(map[route]Formatter, len(l.routes)+1)
+	for r, rf := range l.routes {
+		routes[r] = rf
+	}
+	for _, s := range severities() {
+		if level&s == 0 {
+			continue
+		}
+		if f == nil {
+			delete(routes, route{h, s})
+		} else {
+			routes[route{h, s}] = f
+		}
+	}
+	l.routes = routes
+}
+
+// SetEmitNumericLevel sets whether each line gets a level_num field with the severity as
+// a number, which is handy for log stores that sort or filter on severity.
+// The numbering follows syslog: emerg 0, alert 1, crit 2, err 3, warning 4, notice 5, info 6
+// and debug 7. Syslog has nothing below debug, trace is 7 as well.
+func (l *Logger4go) SetEmitNumericLevel(emit bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.numericLevel = emit
+}
+
+// SetStaticFields sets key/value pairs added to each line of the logger in the given
+// order, e.g. a fixed service identity. The text formatter writes them as key=value after
+// the message and before the line's own fields, which take precedence for the same key.
+// A trailing key without a value gets an empty value. Call with no pairs to remove them.
+//
+//	lg.SetStaticFields("service", "api", "region", "eu-north-1")
+//	lg.Info("Started") // main 2013/06/21 08:22:14  info     Started service=api region=eu-north-1
+func (l *Logger4go) SetStaticFields(kv ...string) {
+	var static []string
+	if len(kv) > 0 {
+		static = append(static, kv...)
+		if len(static)%2 != 0 {
+			static = append(static, "")
+		}
+	}
+
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	l.filter = f
+	l.static = static
+}
+
+// SetWriteErrorHandler sets a function called with the handler and the error when a
+// handler fails to write a line, e.g. on a full disk. The line is still written to the
+// other handlers. fn is called after the line is written out and may log, but not to the
+// failing handler, which would call fn again. Lines written with the embedded log.Logger
+// methods, e.g. Println, report the errors while its output is locked and fn must not log
+// to the same logger then. Set nil to ignore write errors, the default.
+func (l *Logger4go) SetWriteErrorHandler(fn func(h handler.Handler, err error)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.onWriteError = fn
+}
+
+// WriteRaw writes b as is to the handlers, or the output set with SetOutput, without a
+// header, severity or formatting, e.g. a preformatted access log line. b should end with
+// a newline. The severity filter does not apply but nothing is written while the logger
+// is disabled. It returns the first handler error.
+func (l *Logger4go) WriteRaw(b []byte) (int, error) {
+	if !l.IsEnabled() {
+		return len(b), nil
+	}
+
+	l.outMutex.Lock()
+	defer l.outMutex.Unlock()
+
+	return l.Logger.Writer().Write(b)
+}
+
+// LevelWriter returns a writer logging each Write as one line with the given severity,
+// without the trailing newline, e.g. for libraries that log to a *log.Logger or an io.Writer.
+//
+//	srv := &http.Server{ErrorLog: log.New(lg.LevelWriter(logger.WarningSeverity), "", 0)}
+func (l *Logger4go) LevelWriter(level SeverityFilter) io.Writer {
+	return &levelWriter{l: l, level: level}
+}
+
+// levelWriter logs each Write with a severity.
+type levelWriter struct {
+	l     *Logger4go
+	level SeverityFilter
+}
+
+// Write logs b as one line.
+func (lw *levelWriter) Write(b []byte) (int, error) {
+	lw.l.doPrintf(lw.level, "%s", strings.TrimSuffix(string(b), "\n"))
+	return len(b), nil
+}
+
+// SetUTC sets whether the line timestamps are in UTC instead of local time, the same as
+// the log.LUTC flag. The daily rotation of the handlers that support it, e.g. FileHandler,
+// is aligned to midnight UTC as well, also for handlers added later.
+func (l *Logger4go) SetUTC(utc bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if utc {
+		l.Logger.SetFlags(l.Logger.Flags() | log.LUTC)
+	} else {
+		l.Logger.SetFlags(l.Logger.Flags() &^ log.LUTC)
+	}
+	for _, h := range l.handlers {
+		setHandlerUTC(h, utc)
+	}
+}
+
+// IsUTC returns true if the line timestamps are in UTC.
+func (l *Logger4go) IsUTC() bool {
+	return l.Flags()&log.LUTC != 0
+}
+
+// utcSetter is implemented by handlers that can use UTC, e.g. for daily rotation.
+type utcSetter interface {
+	SetUTC(utc bool)
+}
+
+// setHandlerUTC sets h, or the handler wrapped by a FilteringHandler, to use UTC.
+func setHandlerUTC(h handler.Handler, utc bool) {
+	if fh, ok := h.(*FilteringHandler); ok {
+		h = fh.Handler
+	}
+	if us, ok := h.(utcSetter); ok {
+		us.SetUTC(utc)
+	}
 }
 
 // Flags returns the current set of logger flags
@@ -460,38 +1205,294 @@ func (l *Logger4go) SetPrefix(prefix string) {
 	l.Logger.SetPrefix(prefix)
 }
 
-// SetOutput sets a writer
+// SetOutput sets a writer that all lines are written to instead of the handlers, until
+// a handler is added. It is safe to call while logging, the embedded log.Logger is
+// never replaced, only its output.
 func (l *Logger4go) SetOutput(out io.Writer) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	l.Logger = log.New(out, l.Logger.Prefix(), l.Logger.Flags())
+	l.out = out
+	l.Logger.SetOutput(out)
+}
+
+// OutputWriter returns the writer the lines are currently written to, the writer to the
+// handlers or the one set with SetOutput, e.g. to restore it after redirecting the output
+// in a test. Adding or removing a handler replaces it with the writer to the handlers.
+//
+//	out := lg.OutputWriter()
+//	lg.SetOutput(&buf)
+//	defer lg.SetOutput(out)
+func (l *Logger4go) OutputWriter() io.Writer {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.Logger.Writer()
 }
 
 //
 // Private
 //
 var mu = &sync.RWMutex{}
+
+// exit is called by Fatal, replaced in tests
+var exit = os.Exit
 var loggers4go = make(map[string]*Logger4go)
 
+// callDepth is the number of stack frames between the caller of a log method and output.
+// The log methods and the package functions call doPrintf or logFields directly to keep it fixed.
+const callDepth = 3
+
 func (l *Logger4go) doPrintf(f SeverityFilter, format string, v ...interface{}) {
-	if l.IsFilterSet(f) {
-		l.Printf(fmt.Sprintf("%s ", f) + format, v...)
+	if l.IsEnabled() && l.IsFilterSet(f) && l.rateAllowed(f) {
+		l.output(f, nil, fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger4go) logFields(f SeverityFilter, fields Fields, format string, v ...interface{}) {
+	if l.IsEnabled() && l.IsFilterSet(f) && l.rateAllowed(f) {
+		l.output(f, fields, fmt.Sprintf(format, v...))
+	}
+}
+
+// output formats a log entry and writes it out.
+func (l *Logger4go) output(f SeverityFilter, fields Fields, msg string) {
+	l.outputAt(now(), f, fields, msg, 0)
+}
+
+// outputAt formats a log entry logged at t from the call site pc and writes it out.
+// If pc is 0 the call site is looked up from the caller of output.
+func (l *Logger4go) outputAt(t time.Time, f SeverityFilter, fields Fields, msg string, pc uintptr) {
+	l.mutex.Lock()
+	numericLevel, sampler, static := l.numericLevel, l.sampler, l.static
+	l.mutex.Unlock()
+	if sampler != nil && !sampler.allow(f, msg) {
+		return
+	}
+	if numericLevel {
+		fields = mergeFields(fields, Fields{"level_num": levelNum(f)})
+	}
+
+	e := &Entry{Time: t, Level: f, Name: l.name, Prefix: l.Prefix(), Flags: l.Flags(), Message: msg, Fields: resolveFields(fields), Static: static}
+	if e.Flags&(log.Lshortfile|log.Llongfile) != 0 {
+		if pc != 0 {
+			frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+			e.File, e.Line, e.Func = frame.File, frame.Line, frame.Function
+		} else {
+			pc, file, line, ok := runtime.Caller(callDepth + 1)
+			if !ok {
+				file = "???"
+			} else if fn := runtime.FuncForPC(pc); fn != nil {
+				e.Func = fn.Name()
+			}
+			e.File, e.Line = file, line
+		}
+		if e.File == "" {
+			e.File = "???"
+		}
+	}
+
+	l.writeEntry(e)
+}
+
+// writeEntry formats e and writes it to the handlers.
+func (l *Logger4go) writeEntry(e *Entry) {
+	if l.lazyDefaults {
+		InitDefaults()
+	}
+	f := e.Level
+	b, err := l.Formatter().Format(e)
+	if err != nil {
+		return
+	}
+
+	l.mutex.Lock()
+	handlers, mw, routes, onError := l.handlers, l.mw, l.routes, l.onWriteError
+	l.mutex.Unlock()
+
+	// called after the output is released so that the callback may log
+	var failed []handler.Handler
+	var errs []error
+	defer func() {
+		for i, h := range failed {
+			onError(h, errs[i])
+		}
+	}()
+
+	l.outMutex.Lock()
+	defer l.outMutex.Unlock()
+
+	out := l.Logger.Writer()
+	if len(handlers) == 0 || out != mw {
+		// output set with SetOutput
+		out.Write(b)
+		return
+	}
+	var routed map[Formatter][]byte
+	for _, h := range handlers {
+		if fh, ok := h.(*FilteringHandler); ok && !fh.IsFilterSet(f) {
+			continue
+		}
+		if eh, ok := asEntryHandler(h); ok {
+			eh.handleEntry(e)
+			continue
+		}
+		hb := b
+		if rf, ok := routes[route{h, f}]; ok {
+			if hb, ok = routed[rf]; !ok {
+				if hb, err = rf.Format(e); err != nil {
+					continue
+				}
+				if routed == nil {
+					routed = make(map[Formatter][]byte)
+				}
+				routed[rf] = hb
+			}
+		}
+		if _, err := writeHandler(h, f, hb); err != nil && onError != nil {
+			failed, errs = append(failed, h), append(errs, err)
+		}
 	}
 }
 
+// asEntryHandler returns h, or the handler wrapped by a FilteringHandler, if it works
+// on log entries.
+func asEntryHandler(h handler.Handler) (entryHandler, bool) {
+	if fh, ok := h.(*FilteringHandler); ok {
+		h = fh.Handler
+	}
+	eh, ok := h.(entryHandler)
+	return eh, ok
+}
+
+// route is a handler and severity with its own formatter.
+type route struct {
+	h handler.Handler
+	f SeverityFilter
+}
+
+// severityWriter is implemented by handlers that treat severities differently,
+// e.g. the SyslogHandler with per-severity tags.
+type severityWriter interface {
+	WriteSeverity(severity syslog.Priority, b []byte) (int, error)
+}
+
+// LevelHandler is implemented by handlers that need the level of each line, e.g. to
+// treat errors differently. WriteLevel is called instead of Write for lines logged with
+// a level.
+type LevelHandler interface {
+	handler.Handler
+	WriteLevel(level SeverityFilter, b []byte) (int, error)
+}
+
+// writeHandler writes b to h, passing on the level if h makes use of it.
+func writeHandler(h handler.Handler, f SeverityFilter, b []byte) (int, error) {
+	if lh, ok := h.(LevelHandler); ok {
+		return lh.WriteLevel(f, b)
+	}
+	if sw, ok := h.(severityWriter); ok {
+		if severity, ok := syslogSeverity(f); ok {
+			return sw.WriteSeverity(severity, b)
+		}
+	}
+	return h.Write(b)
+}
+
+// fatal closes the handlers and exits.
+func (l *Logger4go) fatal() {
+	l.flushSampler()
+	l.mutex.Lock()
+	handlers := l.handlers
+	l.mutex.Unlock()
+
+	for _, h := range handlers {
+		h.Close()
+	}
+	exit(1)
+}
+
+// levelNum returns the syslog severity number of f.
+func levelNum(f SeverityFilter) int {
+	severity, _ := syslogSeverity(f)
+	return int(severity)
+}
+
 func newLogger(out io.Writer, name string, prefix string, flags int) *Logger4go {
-	return &Logger4go{name: name, Logger: log.New(out, prefix, flags)}
+	l := &Logger4go{name: name, out: out, Logger: log.New(out, prefix, flags)}
+	l.mw = &handlerWriter{l}
+	return l
 }
 
-func registerHandler(l *Logger4go, handler handler.Handler) {
+func registerHandler(l *Logger4go, h handler.Handler) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	l.handlers = append(l.handlers, handler)
-	out := make([]io.Writer, 0)
-	for _, h := range l.handlers {
-		out = append(out, h)
+	if l.verifyOnAdd {
+		if err := verifyHandler(h); err != nil {
+			return fmt.Errorf("Unable to write to %v: %v", h, err)
+		}
+	}
+
+	if l.Logger.Flags()&log.LUTC != 0 {
+		setHandlerUTC(h, true)
+	}
+	// copy on write, output may be iterating over the old list
+	l.handlers = append(l.handlers[:len(l.handlers):len(l.handlers)], h)
+	if l.out != l.mw {
+		// not if unchanged, the first write to a default logger adds its handlers
+		// while the embedded log.Logger holds its output
+		l.out = l.mw
+		l.Logger.SetOutput(l.mw)
+	}
+	return nil
+}
+
+// handlerWriter writes to the handlers of a logger. It is the output of the
+// embedded log.Logger so that it stays the same as handlers are added and removed.
+type handlerWriter struct {
+	l *Logger4go
+}
+
+// Write writes b to each handler, also to the ones after a failing handler unlike
+// io.MultiWriter, and returns the first error. The errors are passed to the write
+// error handler.
+func (hw *handlerWriter) Write(b []byte) (n int, err error) {
+	if !hw.l.IsEnabled() {
+		return len(b), nil
+	}
+	if hw.l.lazyDefaults {
+		InitDefaults()
+	}
+	hw.l.mutex.Lock()
+	handlers, onError := hw.l.handlers, hw.l.onWriteError
+	hw.l.mutex.Unlock()
+
+	for _, h := range handlers {
+		hn, herr := h.Write(b)
+		if herr == nil && hn != len(b) {
+			herr = io.ErrShortWrite
+		}
+		if herr == nil {
+			continue
+		}
+		if err == nil {
+			n, err = hn, herr
+		}
+		if onError != nil {
+			onError(h, herr)
+		}
+	}
+	if err != nil {
+		return n, err
+	}
+	return len(b), nil
+}
+
+// verifyHandler checks that a handler can write. Handlers that can't verify
+// themselves are assumed to be writable, nothing is written to them.
+func verifyHandler(h handler.Handler) error {
+	if v, ok := h.(handler.Verifier); ok {
+		return v.Verify()
 	}
-	l.Logger = log.New(io.MultiWriter(out...), l.Prefix(), l.Flags())
+	return nil
 }
@@ -1,11 +1,24 @@
 package logger
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/alyu/logger/handler"
 )
 
 var lg *Logger4go
@@ -104,3 +117,1183 @@ func startThreads() {
 	go simulateEvent("Long jump", 6)    //start long jump, it       should take 6 seconds
 	go simulateEvent("High jump", 3)    //start Highh jump, it should take 3 seconds
 }
+
+// readOnlyHandler writes to a file opened read-only.
+type readOnlyHandler struct {
+	f *os.File
+}
+
+func (rh *readOnlyHandler) Write(b []byte) (int, error) { return rh.f.Write(b) }
+func (rh *readOnlyHandler) Close() error                { return rh.f.Close() }
+func (rh *readOnlyHandler) String() string              { return "readOnlyHandler" }
+
+// Verify checks the file with an empty write.
+func (rh *readOnlyHandler) Verify() error {
+	_, err := rh.f.Write(nil)
+	return err
+}
+
+func TestVerifyOnAdd(t *testing.T) {
+	f, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lg := Get("verify")
+	if err := lg.AddHandler(&readOnlyHandler{f}); err != nil {
+		t.Errorf("Handlers are not verified by default: %v", err)
+	}
+	lg.RemoveHandler(lg.Handlers()[0])
+
+	lg.SetVerifyOnAdd(true)
+	if err := lg.AddHandler(&readOnlyHandler{f}); err == nil {
+		t.Error("Expected adding a read-only destination to fail")
+	}
+	if len(lg.Handlers()) != 0 {
+		t.Errorf("The failed handler should not be registered: %v", lg.Handlers())
+	}
+
+	if _, err := lg.AddStdFileHandler(filepath.Join(t.TempDir(), "verify.log")); err != nil {
+		t.Errorf("Expected a writable file to verify: %v", err)
+	}
+
+	mh := handler.NewMemoryHandler()
+	if err := lg.AddHandler(mh); err != nil {
+		t.Errorf("Expected a handler without Verify to be added: %v", err)
+	}
+	if lines := mh.Lines(); len(lines) != 0 {
+		t.Errorf("Expected nothing written to a handler without Verify, got %q", lines)
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for name, want := range map[string]SeverityFilter{
+		"info":      InfoSeverity,
+		"ERR":       ErrSeverity,
+		" Warning ": WarningSeverity,
+		"warn":      WarningSeverity,
+		"all":       AllSeverity,
+	} {
+		f, err := ParseSeverity(name)
+		if err != nil || f != want {
+			t.Errorf("ParseSeverity(%q) = %v, %v; want %v", name, f, err, want)
+		}
+	}
+
+	// round-trip through String
+	for _, f := range []SeverityFilter{EmergSeverity, AlertSeverity, CritSeverity, ErrSeverity, WarningSeverity, NoticeSeverity, InfoSeverity, DebugSeverity, TraceSeverity} {
+		if got, err := ParseSeverity(f.String()); err != nil || got != f {
+			t.Errorf("ParseSeverity(%q) = %v, %v; want %v", f.String(), got, err, f)
+		}
+	}
+
+	if _, err := ParseSeverity("verbose"); err == nil {
+		t.Error("Expected an error for an unknown severity")
+	}
+}
+
+func TestParseSeverityList(t *testing.T) {
+	f, err := ParseSeverityList("err, crit,ALERT")
+	if err != nil || f != ErrSeverity|CritSeverity|AlertSeverity {
+		t.Errorf("Unexpected filter %v, %v", f, err)
+	}
+	if _, err := ParseSeverityList("info,loud"); err == nil {
+		t.Error("Expected an error for an unknown severity in the list")
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := GetWithFlags("syslog_severity", 0)
+	sh, err := l.AddSyslogHandler("udp", conn.LocalAddr().String(), syslog.LOG_INFO|syslog.LOG_LOCAL0, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.RemoveHandler(sh)
+	defer sh.Close()
+
+	receive := func() string {
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	for _, tc := range []struct {
+		log  func(v ...interface{})
+		want syslog.Priority
+	}{
+		{l.Err, syslog.LOG_ERR},
+		{l.Emerg, syslog.LOG_EMERG},
+		{l.Debug, syslog.LOG_DEBUG},
+		{l.Print, syslog.LOG_INFO},
+	} {
+		tc.log("message")
+		want := fmt.Sprintf("<%d>", tc.want|syslog.LOG_LOCAL0)
+		if m := receive(); !strings.HasPrefix(m, want) {
+			t.Errorf("Expected priority %s: %q", want, m)
+		}
+	}
+}
+
+func TestSyslogSeverityTag(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := GetWithFlags("syslog_tags", 0)
+	sh, err := l.AddSyslogHandler("udp", conn.LocalAddr().String(), syslog.LOG_INFO|syslog.LOG_LOCAL0, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.RemoveHandler(sh)
+	defer sh.Close()
+	sh.SetSeverityTag(syslog.LOG_ERR, "app-err")
+
+	receive := func() string {
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	l.Err("disk failure")
+	if m := receive(); !strings.Contains(m, " app-err[") || !strings.Contains(m, "disk failure") {
+		t.Errorf("Expected the err tag: %q", m)
+	}
+	l.Info("all good")
+	if m := receive(); !strings.Contains(m, " app[") || !strings.Contains(m, "all good") {
+		t.Errorf("Expected the handler tag: %q", m)
+	}
+}
+
+func TestSetFilterConcurrent(t *testing.T) {
+	l := GetWithFlags("filter_race", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				l.SetFilter(InfoSeverity | ErrSeverity)
+				l.SetFilter(AllSeverity)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				l.Info("hammering the filter")
+				l.IsFilterSet(DebugSeverity)
+			}
+		}()
+	}
+	wg.Wait()
+
+	l.SetFilter(ErrSeverity)
+	if l.Filter() != ErrSeverity {
+		t.Errorf("Unexpected filter %d", l.Filter())
+	}
+}
+
+func BenchmarkIsFilterSet(b *testing.B) {
+	l := GetWithFlags("filter_bench", 0)
+	l.SetFilter(InfoSeverity)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.IsFilterSet(DebugSeverity)
+		}
+	})
+}
+
+func BenchmarkFilteredOut(b *testing.B) {
+	l := GetWithFlags("filter_bench", 0)
+	l.SetFilter(InfoSeverity)
+	for i := 0; i < b.N; i++ {
+		l.Debugf("filtered %d", i)
+	}
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	f := SeverityAtLeast(WarningSeverity)
+	for _, s := range []SeverityFilter{EmergSeverity, AlertSeverity, CritSeverity, ErrSeverity, WarningSeverity} {
+		if f&s == 0 {
+			t.Errorf("Expected %v to be included", s)
+		}
+	}
+	for _, s := range []SeverityFilter{NoticeSeverity, InfoSeverity, DebugSeverity, TraceSeverity} {
+		if f&s != 0 {
+			t.Errorf("Expected %v to be excluded", s)
+		}
+	}
+
+	if f := SeverityAtLeast(DebugSeverity); f != AllSeverity&^TraceSeverity {
+		t.Errorf("Expected all severities but trace, got %d", f)
+	}
+	if f := SeverityAtLeast(TraceSeverity); f != AllSeverity {
+		t.Errorf("Expected all severities, got %d", f)
+	}
+	if f := SeverityAtLeast(EmergSeverity); f != EmergSeverity {
+		t.Errorf("Expected emerg only, got %d", f)
+	}
+}
+
+func TestFatal(t *testing.T) {
+	code := -1
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	path := filepath.Join(t.TempDir(), "fatal.log")
+	fh, err := handler.NewFileHandler(path, 0, 0, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := GetWithFlags("fatal", 0)
+	// the message is queued, it must be written out before exiting
+	if err = l.AddHandler(handler.NewAsyncHandler(fh, 10, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Fatalf("giving up after %d retries", 3)
+	if code != 1 {
+		t.Errorf("Expected exit status 1, got %d", code)
+	}
+	b, _ := os.ReadFile(path)
+	if want := "fatal  crit     giving up after 3 retries\n"; string(b) != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", b, want)
+	}
+}
+
+func TestWriterHandler(t *testing.T) {
+	var buf bytes.Buffer
+	l := GetWithFlags("writer", 0)
+	if err := l.AddHandler(handler.NewWriterHandler(&buf, "buffer")); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Info("one")
+	l.Warnf("two %d", 2)
+	want := "writer  info     one\nwriter  warning  two 2\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+	}
+}
+
+func TestStaticFields(t *testing.T) {
+	l := GetWithFlags("static", 0)
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+	l.SetPrefix("api ")
+	l.SetStaticFields("service", "api", "region", "eu-north-1", "build")
+
+	l.Info("started")
+	l.Errf("failed after %d tries", 3)
+	l.SetStaticFields()
+	l.Info("plain")
+
+	want := []string{
+		"api  info     started service=api region=eu-north-1 build=\"\"",
+		"api  err      failed after 3 tries service=api region=eu-north-1 build=\"\"",
+		"api  info     plain",
+	}
+	if lines := mh.Lines(); !reflect.DeepEqual(lines, want) {
+		t.Errorf("Unexpected lines:\n got  %q\n want %q", lines, want)
+	}
+}
+
+func TestWriteErrorHandler(t *testing.T) {
+	l := GetWithFlags("writeerr", 0)
+	errFull := errors.New("disk full")
+	failing := handler.NewFuncHandler(func(b []byte) (int, error) { return 0, errFull })
+	mh := handler.NewMemoryHandler()
+	fh, _ := l.AddFilteredHandler(failing, InfoSeverity)
+	l.AddHandler(mh)
+
+	l.Info("ignored error")
+
+	var gotH handler.Handler
+	var gotErr error
+	l.SetWriteErrorHandler(func(h handler.Handler, err error) {
+		gotH, gotErr = h, err
+		// logging from the callback must not deadlock, debug lines skip the failing handler
+		l.Debug("write failed")
+	})
+	l.Info("reported error")
+
+	if gotH != fh || !errors.Is(gotErr, errFull) {
+		t.Errorf("Expected the failing handler and its error, got %v, %v", gotH, gotErr)
+	}
+	if lines := mh.Lines(); len(lines) != 3 || !strings.HasSuffix(lines[1], "reported error") {
+		t.Errorf("Expected the other handler to get all lines, got %q", lines)
+	}
+}
+
+func TestWriteAllHandlers(t *testing.T) {
+	l := GetWithFlags("writeall", 0)
+	l.AddHandler(handler.NewFuncHandler(func(b []byte) (int, error) { return 0, errors.New("broken socket") }))
+	mh1, mh2 := handler.NewMemoryHandler(), handler.NewMemoryHandler()
+	l.AddHandler(mh1)
+	l.AddHandler(mh2)
+	failures := 0
+	l.SetWriteErrorHandler(func(h handler.Handler, err error) { failures++ })
+
+	l.Info("one")
+	// the embedded log.Logger writes through the same handlers
+	l.Println("two")
+
+	for _, mh := range []*handler.MemoryHandler{mh1, mh2} {
+		if lines := mh.Lines(); len(lines) != 2 {
+			t.Errorf("Expected both lines after the failing handler, got %q", lines)
+		}
+	}
+	if failures != 2 {
+		t.Errorf("Expected 2 write errors, got %d", failures)
+	}
+}
+
+// handlersAtImport is the number of default handlers before any test runs.
+var handlersAtImport = len(Logger.Handlers())
+
+func TestInitDefaults(t *testing.T) {
+	if handlersAtImport != 0 {
+		t.Errorf("Expected no handlers after import, got %d", handlersAtImport)
+	}
+
+	InitDefaults()
+	n := len(Logger.Handlers())
+	InitDefaults()
+	if len(Logger.Handlers()) != n {
+		t.Error("Expected InitDefaults to add the handlers once")
+	}
+	if Def() != Logger || Stdout() != Get("main") {
+		t.Error("Expected Def and Stdout to return the default logger")
+	}
+}
+
+func TestLazyDefaults(t *testing.T) {
+	keepRegistry(t)
+	saved, stdout, stderr := Logger, os.Stdout, os.Stderr
+	t.Cleanup(func() {
+		Logger, os.Stdout, os.Stderr = saved, stdout, stderr
+		defaultsOnce = sync.Once{}
+		defaultsOnce.Do(func() {})
+	})
+
+	// as after import, with nothing initialized
+	mu.Lock()
+	loggers4go = make(map[string]*Logger4go)
+	mu.Unlock()
+	defaultsOnce = sync.Once{}
+	Logger = Get("main")
+	var err error
+	if os.Stdout, err = os.Create(filepath.Join(t.TempDir(), "stdout")); err != nil {
+		t.Fatal(err)
+	}
+	if os.Stderr, err = os.Create(filepath.Join(t.TempDir(), "stderr")); err != nil {
+		t.Fatal(err)
+	}
+
+	Logger.Info("first line")
+	Logger.Logger.Print("through the embedded logger")
+	Get("err").Err("to stderr")
+
+	for f, want := range map[*os.File][]string{os.Stdout: {"first line", "through the embedded logger"}, os.Stderr: {"to stderr"}} {
+		b, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range want {
+			if !strings.Contains(string(b), w) {
+				t.Errorf("Expected %q in %s, got %q", w, filepath.Base(f.Name()), b)
+			}
+		}
+	}
+}
+
+func TestLevelWriter(t *testing.T) {
+	l := GetWithFlags("levelwriter", 0)
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+	l.SetMinLevel(WarningSeverity)
+
+	log.New(l.LevelWriter(WarningSeverity), "http: ", 0).Print("TLS handshake error")
+	log.New(l.LevelWriter(DebugSeverity), "", 0).Print("filtered")
+	l.LevelWriter(ErrSeverity).Write([]byte("no newline"))
+
+	want := []string{
+		"levelwriter  warning  http: TLS handshake error",
+		"levelwriter  err      no newline",
+	}
+	if lines := mh.Lines(); !reflect.DeepEqual(lines, want) {
+		t.Errorf("Unexpected lines:\n got  %q\n want %q", lines, want)
+	}
+	// the methods of the embedded log.Logger are not shadowed
+	var _ interface {
+		Output(calldepth int, s string) error
+		Writer() io.Writer
+	} = l
+}
+
+func TestWriteRaw(t *testing.T) {
+	l := GetWithFlags("raw", log.LstdFlags)
+	var buf bytes.Buffer
+	l.AddHandler(handler.NewWriterHandler(&buf, ""))
+	l.SetFilter(ErrSeverity)
+
+	line := `127.0.0.1 - - [21/Jun/2013:08:22:14 +0200] "GET / HTTP/1.1" 200 512` + "\n"
+	n, err := l.WriteRaw([]byte(line))
+	if err != nil || n != len(line) {
+		t.Fatalf("WriteRaw returned %d, %v", n, err)
+	}
+	if buf.String() != line {
+		t.Errorf("Expected the line verbatim, got %q", buf.String())
+	}
+}
+
+func TestOutputWriter(t *testing.T) {
+	l := GetWithFlags("output", 0)
+	var handled bytes.Buffer
+	wh := handler.NewWriterHandler(&handled, "")
+	l.AddHandler(wh)
+	defer l.RemoveHandler(wh)
+
+	out := l.OutputWriter()
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+	if l.OutputWriter() != &buf {
+		t.Errorf("Expected the redirected output")
+	}
+	l.Info("redirected")
+	l.SetOutput(out)
+	l.Info("restored")
+
+	if !strings.Contains(buf.String(), "redirected") || strings.Contains(buf.String(), "restored") {
+		t.Errorf("Unexpected redirected output %q", buf.String())
+	}
+	if strings.Contains(handled.String(), "redirected") || !strings.Contains(handled.String(), "restored") {
+		t.Errorf("Unexpected handler output %q", handled.String())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	mh := handler.NewMemoryHandler()
+	// a slow destination so lines are still queued when Flush is called
+	slow := handler.NewFuncHandler(func(b []byte) (int, error) {
+		time.Sleep(time.Millisecond)
+		return mh.Write(b)
+	})
+	l := GetWithFlags("flush", 0)
+	if err := l.AddHandler(handler.NewAsyncHandler(slow, 100, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		l.Infof("line %d", i)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(mh.Lines()); n != 50 {
+		t.Fatalf("Expected 50 lines after Flush, got %d", n)
+	}
+
+	// the logger is still open
+	l.Info("after flush")
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := mh.Lines(); len(lines) != 51 || !strings.Contains(lines[50], "after flush") {
+		t.Errorf("Expected the line logged after Flush, got %q", lines[len(lines)-1])
+	}
+}
+
+func TestPanic(t *testing.T) {
+	l := GetWithFlags("panic", 0)
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+
+	defer func() {
+		if r := recover(); r != "bad state 42" {
+			t.Errorf("Unexpected panic value %q", r)
+		}
+		if want := "panic  crit     bad state 42\n"; buf.String() != want {
+			t.Errorf("Unexpected output:\n got  %q\n want %q", buf.String(), want)
+		}
+	}()
+	l.Panicf("bad state %d", 42)
+}
+
+func TestAddStdoutHandlerClosed(t *testing.T) {
+	l := GetWithFlags("closed_stdout", 0)
+	if _, err := l.AddStdoutHandler(); err != nil {
+		t.Fatalf("AddStdoutHandler failed: %v", err)
+	}
+
+	_, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	n := len(l.Handlers())
+	if sh, err := l.AddStdoutHandler(); err == nil || sh != nil {
+		t.Error("Expected an error for a closed stdout")
+	}
+	if len(l.Handlers()) != n {
+		t.Error("Handler added for a closed stdout")
+	}
+}
+
+func TestSetMinLevel(t *testing.T) {
+	l := GetWithFlags("min_level", 0)
+	defer l.SetFilter(AllSeverity)
+
+	l.SetMinLevel(NoticeSeverity)
+	if !l.IsFilterSet(NoticeSeverity|ErrSeverity|EmergSeverity) || l.IsFilterSet(InfoSeverity) || l.IsFilterSet(DebugSeverity) {
+		t.Errorf("Unexpected filter %d", l.Filter())
+	}
+
+	// a new level inserted between notice and info, and one after trace
+	const verboseSeverity, wireSeverity SeverityFilter = 1 << 10, 1 << 9
+	order := severityOrder
+	severityOrder = []SeverityFilter{EmergSeverity, AlertSeverity, CritSeverity, ErrSeverity, WarningSeverity,
+		NoticeSeverity, verboseSeverity, InfoSeverity, DebugSeverity, TraceSeverity, wireSeverity}
+	defer func() { severityOrder = order }()
+
+	l.SetMinLevel(NoticeSeverity)
+	if !l.IsFilterSet(NoticeSeverity) || l.IsFilterSet(verboseSeverity) || l.IsFilterSet(InfoSeverity) {
+		t.Errorf("Unexpected filter %d", l.Filter())
+	}
+	l.SetMinLevel(InfoSeverity)
+	if !l.IsFilterSet(verboseSeverity|InfoSeverity|WarningSeverity) || l.IsFilterSet(DebugSeverity) || l.IsFilterSet(wireSeverity) {
+		t.Errorf("Unexpected filter %d", l.Filter())
+	}
+	l.SetMinLevel(wireSeverity)
+	if !l.IsFilterSet(AllSeverity | verboseSeverity | wireSeverity) {
+		t.Errorf("Unexpected filter %d", l.Filter())
+	}
+}
+
+func TestTrace(t *testing.T) {
+	l := GetWithFlags("trace", 0)
+	defer l.SetFilter(AllSeverity)
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+
+	l.Tracef("sent %d bytes", 42)
+	l.SetFilter(AllSeverity &^ TraceSeverity)
+	l.Trace("filtered")
+	l.Debug("passes")
+
+	want := []string{
+		"trace  trace    sent 42 bytes",
+		"trace  debug    passes",
+	}
+	if lines := mh.Lines(); !reflect.DeepEqual(lines, want) {
+		t.Errorf("Unexpected lines:\n got  %q\n want %q", lines, want)
+	}
+}
+
+func TestAddHandlerKeepsFlags(t *testing.T) {
+	l := Get("keep_flags")
+	l.SetFlags(log.Lmsgprefix)
+	l.SetPrefix("custom: ")
+	logger := l.Logger
+
+	out := &bufferHandler{}
+	if err := l.AddHandler(out); err != nil {
+		t.Fatal(err)
+	}
+	defer l.RemoveHandler(out)
+
+	if l.Logger != logger {
+		t.Error("Embedded log.Logger replaced")
+	}
+	if l.Flags() != log.Lmsgprefix || l.Prefix() != "custom: " {
+		t.Errorf("Flags or prefix lost: %d %q", l.Flags(), l.Prefix())
+	}
+	l.Println("hello")
+	if want := "custom: hello\n"; out.String() != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", out.String(), want)
+	}
+}
+
+// failingCloseHandler fails to close.
+type failingCloseHandler struct{ bufferHandler }
+
+func (fh *failingCloseHandler) Close() error { return fmt.Errorf("busy") }
+
+// keepRegistry restores the logger registry and the loggers' handlers after a test closing them.
+func keepRegistry(t *testing.T) {
+	mu.Lock()
+	saved := make(map[string]*Logger4go)
+	handlers := make(map[*Logger4go][]handler.Handler)
+	for name, l := range loggers4go {
+		saved[name] = l
+		handlers[l] = l.Handlers()
+	}
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		loggers4go = saved
+		mu.Unlock()
+		for l, hs := range handlers {
+			l.mutex.Lock()
+			l.handlers = hs
+			l.mutex.Unlock()
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	keepRegistry(t)
+	mu.Lock()
+	loggers4go = make(map[string]*Logger4go)
+	mu.Unlock()
+
+	for _, name := range []string{"list_c", "list_a", "list_b"} {
+		Get(name)
+	}
+	GetWithFlags("list_a", 0)
+	if names := List(); !reflect.DeepEqual(names, []string{"list_a", "list_b", "list_c"}) {
+		t.Errorf("Unexpected names %q", names)
+	}
+}
+
+func TestSetGlobalFilter(t *testing.T) {
+	keepRegistry(t)
+	var ls []*Logger4go
+	for i, f := range []SeverityFilter{ErrSeverity, SeverityAtLeast(WarningSeverity), InfoSeverity} {
+		l := Get(fmt.Sprintf("global_%d", i))
+		l.SetFilter(f)
+		ls = append(ls, l)
+	}
+	// restore the filters of the other tests' loggers
+	filters := make(map[*Logger4go]SeverityFilter)
+	mu.RLock()
+	for _, l := range loggers4go {
+		filters[l] = l.Filter()
+	}
+	mu.RUnlock()
+	defer func() {
+		for l, f := range filters {
+			l.SetFilter(f)
+		}
+	}()
+
+	SetGlobalFilter(DebugSeverity)
+	for _, l := range ls {
+		if l.Filter() != DebugSeverity {
+			t.Errorf("Expected %s to have the debug filter, got %s", l.name, filterNames(l.Filter()))
+		}
+	}
+
+	if err := SetFilterByName("global_1", ErrSeverity); err != nil {
+		t.Fatal(err)
+	}
+	if ls[1].Filter() != ErrSeverity || ls[0].Filter() != DebugSeverity {
+		t.Error("Expected SetFilterByName to change only the named logger")
+	}
+	if err := SetFilterByName("no_such_logger", ErrSeverity); err == nil {
+		t.Error("Expected an error for an unknown logger")
+	}
+}
+
+func TestGetWithOptions(t *testing.T) {
+	l := GetWithOptions("svc", WithPrefix(""), WithFlags(0))
+	if Get("svc") != l {
+		t.Error("Expected the logger registered as svc")
+	}
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+	l.Info("bare")
+
+	want := []string{" info     bare"}
+	if lines := mh.Lines(); !reflect.DeepEqual(lines, want) {
+		t.Errorf("Unexpected lines:\n got  %q\n want %q", lines, want)
+	}
+	if l.Flags() != 0 {
+		t.Errorf("Expected no flags, got %d", l.Flags())
+	}
+}
+
+func TestClone(t *testing.T) {
+	keepRegistry(t)
+	path := filepath.Join(t.TempDir(), "clone.log")
+	l := GetWithFlags("orig", 0)
+	fh, err := l.AddFileHandler(path, 0, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	l.SetMinLevel(InfoSeverity)
+	l.SetStaticFields("service", "api")
+	l.SetEmitNumericLevel(true)
+	l.SetRateLimit(ErrSeverity, 1)
+
+	c, err := l.Clone("clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Get("clone") != c {
+		t.Error("Expected the clone to be registered")
+	}
+	c.SetMinLevel(ErrSeverity)
+	if l.Filter() != SeverityAtLeast(InfoSeverity) {
+		t.Errorf("Expected the original filter to be unchanged, got %s", filterNames(l.Filter()))
+	}
+
+	l.Info("from original")
+	c.Info("filtered")
+	c.Err("from clone")
+	c.Err("rate limited")
+	b, _ := os.ReadFile(path)
+	want := "orig  info     from original service=api level_num=6\nclone  err      from clone service=api level_num=3\n"
+	if string(b) != want {
+		t.Errorf("Unexpected output:\n got  %q\n want %q", b, want)
+	}
+
+	for _, name := range []string{"clone", "main"} {
+		if _, err := l.Clone(name); err == nil {
+			t.Errorf("Expected an error cloning to the existing logger %q", name)
+		}
+	}
+	if Get("main") == l || Get("clone") != c {
+		t.Error("Expected the existing loggers to stay registered")
+	}
+}
+
+func TestCloseAll(t *testing.T) {
+	keepRegistry(t)
+
+	dir := t.TempDir()
+	var fhs []*handler.FileHandler
+	for _, name := range []string{"close_a", "close_b", "close_c"} {
+		fh, err := Get(name).AddFileHandler(filepath.Join(dir, name+".log"), 0, 0, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fhs = append(fhs, fh)
+	}
+	Get("close_c").AddHandler(&failingCloseHandler{})
+
+	err := CloseAll()
+	if err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("Expected the close error, got %v", err)
+	}
+	for i, fh := range fhs {
+		if _, err := fh.Write([]byte("after close\n")); err == nil {
+			t.Errorf("File handler %d still open", i)
+		}
+	}
+
+	mu.RLock()
+	n := len(loggers4go)
+	mu.RUnlock()
+	if n != 0 {
+		t.Errorf("Expected an empty registry, got %d loggers", n)
+	}
+}
+
+func BenchmarkLogDiscard(b *testing.B) {
+	l := GetWithFlags("bench_discard", log.LstdFlags)
+	dh := handler.NewDiscardHandler()
+	l.AddHandler(dh)
+	defer l.RemoveHandler(dh)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Infof("request %d done", i)
+	}
+	b.ReportMetric(float64(dh.Bytes())/float64(b.N), "bytes/line")
+}
+
+func TestDescribe(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := GetWithFlags("describe", 0)
+	path := filepath.Join(t.TempDir(), "app.log")
+	if _, err = l.AddStdoutHandler(); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := l.AddFileHandler(path, uint(10*handler.MB), 5, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	addr := conn.LocalAddr().String()
+	sh, err := l.AddSyslogHandler("udp", addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	if _, err = l.AddFilteredHandler(&handler.StderrHandler{}, SeverityAtLeast(ErrSeverity)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "stdout; file " + path + " (max 10MB, 5 rotations, gzip); syslog udp://" + addr + " (local0); stderr [emerg,alert,crit,err]"
+	if got := l.Describe(); got != want {
+		t.Errorf("Unexpected description:\n got  %q\n want %q", got, want)
+	}
+}
+
+func TestLogStartupBanner(t *testing.T) {
+	l := GetWithFlags("banner", 0)
+	path := filepath.Join(t.TempDir(), "banner.log")
+	fh, err := l.AddFileHandler(path, 0, 1, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	if _, err = l.AddFilteredHandler(&handler.StderrHandler{}, ErrSeverity); err != nil {
+		t.Fatal(err)
+	}
+	l.SetMinLevel(InfoSeverity)
+
+	l.LogStartupBanner()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `banner  info     Logger started destinations="file ` + path + ` (1 rotations, daily); stderr [err]" filter=emerg,alert,crit,err,warning,notice,info` + "\n"
+	if got := string(b); got != want {
+		t.Errorf("Unexpected banner:\n got  %q\n want %q", got, want)
+	}
+}
+
+func TestAddRemoveHandlerWhileLogging(t *testing.T) {
+	l := GetWithFlags("churn", 0)
+	var bh bufferHandler
+	if err := l.AddHandler(&bh); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, lines = 4, 500
+	done := make(chan struct{})
+	churned := make(chan struct{})
+	go func() {
+		defer close(churned)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			dh := &handler.DiscardHandler{}
+			l.AddHandler(dh)
+			l.Handlers()
+			l.RemoveHandler(dh)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				l.Infof("line %d-%d", w, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	<-churned
+
+	seen := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSuffix(bh.String(), "\n"), "\n") {
+		seen[line[strings.LastIndexByte(line, ' ')+1:]]++
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < lines; i++ {
+			if n := seen[fmt.Sprintf("%d-%d", w, i)]; n != 1 {
+				t.Fatalf("Line %d-%d written %d times", w, i, n)
+			}
+		}
+	}
+	if len(l.Handlers()) != 1 {
+		t.Errorf("Expected only the buffer handler left, got %v", l.Handlers())
+	}
+}
+
+func TestDisable(t *testing.T) {
+	l := GetWithFlags("toggle", 0)
+	mh := handler.NewMemoryHandler()
+	if err := l.AddHandler(mh); err != nil {
+		t.Fatal(err)
+	}
+	l.SetMinLevel(InfoSeverity)
+
+	l.Info("before")
+	l.Disable()
+	if l.IsEnabled() {
+		t.Fatal("Expected the logger to be disabled")
+	}
+	l.Info("while disabled")
+	l.Err("while disabled")
+	l.Print("while disabled")
+	l.Enable()
+	l.Info("after")
+	l.Debug("filtered out")
+
+	want := "[toggle  info     before toggle  info     after]"
+	if got := fmt.Sprint(mh.Lines()); got != want {
+		t.Errorf("Unexpected lines:\n got  %s\n want %s", got, want)
+	}
+	if len(l.Handlers()) != 1 || l.Filter() != SeverityAtLeast(InfoSeverity) {
+		t.Error("Expected the handlers and filter to be kept")
+	}
+}
+
+// levelHandler records the levels of the lines it is given.
+type levelHandler struct {
+	bufferHandler
+	levels []SeverityFilter
+	writes int
+}
+
+func (lh *levelHandler) Write(b []byte) (int, error) {
+	lh.writes++
+	return lh.bufferHandler.Write(b)
+}
+
+func (lh *levelHandler) WriteLevel(level SeverityFilter, b []byte) (int, error) {
+	lh.levels = append(lh.levels, level)
+	return lh.bufferHandler.Write(b)
+}
+
+func TestLevelHandler(t *testing.T) {
+	l := GetWithFlags("leveled", 0)
+	lh := &levelHandler{}
+	l.AddHandler(lh)
+	filtered := &levelHandler{}
+	l.AddFilteredHandler(filtered, AllSeverity)
+
+	l.Err("disk full")
+	l.Info("started")
+
+	want := fmt.Sprint([]SeverityFilter{ErrSeverity, InfoSeverity})
+	for _, h := range []*levelHandler{lh, filtered} {
+		if got := fmt.Sprint(h.levels); got != want || h.writes != 0 {
+			t.Errorf("Expected WriteLevel with %s and no Write, got %s and %d writes", want, got, h.writes)
+		}
+		if !strings.Contains(h.String(), "err      disk full") {
+			t.Errorf("Unexpected output %q", h.String())
+		}
+	}
+}
+
+func TestAddFileHandlerWithSeq(t *testing.T) {
+	l := GetWithFlags("seq", 0)
+	path := filepath.Join(t.TempDir(), "app.log")
+	fh, err := l.AddFileHandlerWithSeq(path, 10, 5, 3, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.RemoveHandler(fh)
+	defer fh.Close()
+
+	l.Info("rotate now")
+	for seq, want := range map[string]bool{".1": false, ".2": false, ".3": true} {
+		if _, err := os.Stat(path + seq); (err == nil) != want {
+			t.Errorf("Expected %s to exist: %v", path+seq, want)
+		}
+	}
+}
+
+func TestHandlersConcurrent(t *testing.T) {
+	l := GetWithFlags("handlers", 0)
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+
+	// the list is a copy
+	hs := l.Handlers()
+	hs[0] = &handler.NoopHandler{}
+	if l.Handlers()[0] != mh {
+		t.Fatal("Changing the returned list changed the logger's handlers")
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			dh := handler.NewDiscardHandler()
+			l.AddHandler(dh)
+			l.RemoveHandler(dh)
+		}
+		close(done)
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, h := range l.Handlers() {
+				_ = h.String()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			l.Info("line")
+		}
+	}()
+	wg.Wait()
+
+	if n := len(mh.Lines()); n != 500 {
+		t.Errorf("Expected 500 lines, got %d", n)
+	}
+	if hs := l.Handlers(); len(hs) != 1 || hs[0] != mh {
+		t.Errorf("Unexpected handlers %v", hs)
+	}
+}
+
+func TestSetOutputWhileLogging(t *testing.T) {
+	l := GetWithFlags("swap", 0)
+	var bh bufferHandler
+	l.AddHandler(&bh)
+	var out bufferHandler
+
+	const writers, lines = 4, 200
+	done := make(chan struct{})
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				l.SetOutput(l.mw)
+				return
+			default:
+			}
+			if i%2 == 0 {
+				l.SetOutput(&out)
+			} else {
+				l.SetOutput(l.mw)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				l.Info("line")
+				l.Print("print")
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-swapped
+
+	// every line went to exactly one of the outputs
+	n := strings.Count(bh.String(), "\n") + strings.Count(out.String(), "\n")
+	if n != 2*writers*lines {
+		t.Errorf("Expected %d lines, got %d", 2*writers*lines, n)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	l := GetWithFlags("enabled", 0)
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+
+	check := func() {
+		t.Helper()
+		for _, s := range severityOrder {
+			mh.Reset()
+			l.doPrintf(s, "line")
+			if written := len(mh.Lines()) == 1; l.Enabled(s) != written {
+				t.Errorf("Enabled(%s) = %v, but written = %v with filter %s", s, l.Enabled(s), written, filterNames(l.Filter()))
+			}
+		}
+	}
+
+	l.SetFilter(AllSeverity)
+	check()
+	l.SetMinLevel(WarningSeverity)
+	check()
+	l.SetFilter(DebugSeverity | ErrSeverity)
+	check()
+	l.Disable()
+	check()
+}
+
+// closeHandler records when it is closed.
+type closeHandler struct {
+	bufferHandler
+	closed chan struct{}
+}
+
+func (ch *closeHandler) Close() error {
+	close(ch.closed)
+	return nil
+}
+
+func TestHandleShutdownSignals(t *testing.T) {
+	keepRegistry(t)
+	ch := &closeHandler{closed: make(chan struct{})}
+	Get("shutdown").AddHandler(ch)
+
+	exited := make(chan int, 1)
+	exit = func(code int) {
+		select {
+		case <-ch.closed:
+		default:
+			t.Error("Exited before the handlers were closed")
+		}
+		exited <- code
+	}
+	defer func() { exit = os.Exit }()
+
+	HandleShutdownSignals(syscall.SIGUSR1)
+	// installing again replaces the handler
+	cancel := HandleShutdownSignals(syscall.SIGUSR1)
+	defer cancel()
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	select {
+	case code := <-exited:
+		if code != 128+int(syscall.SIGUSR1) {
+			t.Errorf("Unexpected exit status %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+	select {
+	case <-exited:
+		t.Error("Shutdown handled more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket allows rate events per second with bursts of up to rate events.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func newTokenBucket(perSecond int, t time.Time) *tokenBucket {
+	return &tokenBucket{rate: float64(perSecond), tokens: float64(perSecond), last: t}
+}
+
+// take returns true and uses a token if one is available at time t.
+func (tb *tokenBucket) take(t time.Time) bool {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	if elapsed := t.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate
+		if tb.tokens > tb.rate {
+			tb.tokens = tb.rate
+		}
+		tb.last = t
+	}
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// SetRateLimit caps the lines of the given severity to perSecond lines per second, allowing
+// bursts of up to perSecond lines. Lines over the limit are dropped and counted, see Dropped.
+// Each level in a combined severity gets its own limit. A perSecond of 0 removes the limit.
+func (l *Logger4go) SetRateLimit(level SeverityFilter, perSecond int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// replace the map, rateAllowed reads it without the lock
+	current := l.rateLimits()
+	limits := make(map[SeverityFilter]*tokenBucket, len(current)+1)
+	for s, tb := range current {
+		limits[s] = tb
+	}
+	for _, s := range severities() {
+		if level&s == 0 {
+			continue
+		}
+		if perSecond > 0 {
+			limits[s] = newTokenBucket(perSecond, now())
+		} else {
+			delete(limits, s)
+		}
+	}
+	if len(limits) == 0 {
+		limits = nil
+	}
+	l.limits.Store(limits)
+}
+
+// rateLimits returns the rate limit per severity, nil if there are none.
+func (l *Logger4go) rateLimits() map[SeverityFilter]*tokenBucket {
+	limits, _ := l.limits.Load().(map[SeverityFilter]*tokenBucket)
+	return limits
+}
+
+// Dropped returns the number of lines dropped by the rate limits.
+func (l *Logger4go) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// rateAllowed returns false and counts the line as dropped if it is over the rate limit.
+func (l *Logger4go) rateAllowed(f SeverityFilter) bool {
+	limits := l.rateLimits()
+	if limits == nil {
+		return true
+	}
+	if tb, ok := limits[f]; ok && !tb.take(now()) {
+		atomic.AddUint64(&l.dropped, 1)
+		return false
+	}
+	return true
+}
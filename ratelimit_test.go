@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alyu/logger/handler"
+)
+
+func TestRateLimit(t *testing.T) {
+	var mutex sync.Mutex
+	clockNow := fixedClock()
+	SetClock(func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return clockNow
+	})
+	defer SetClock(nil)
+	advance := func(d time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		clockNow = clockNow.Add(d)
+	}
+
+	lg := GetWithFlags("limited", 0)
+	lg.SetFilter(AllSeverity)
+	dh := handler.NewDiscardHandler()
+	lg.AddHandler(dh)
+	lg.SetRateLimit(InfoSeverity, 100)
+
+	for i := 0; i < 1000; i++ {
+		lg.Info("burst")
+		advance(100 * time.Microsecond)
+	}
+	// 100 for the burst and 10 refilled over 0.1s
+	if n := dh.Writes(); n < 100 || n > 111 {
+		t.Errorf("Expected about 110 lines, got %d", n)
+	}
+	if n := lg.Dropped(); n != 1000-dh.Writes() {
+		t.Errorf("Expected %d dropped lines, got %d", 1000-dh.Writes(), n)
+	}
+
+	// other severities are not limited
+	written := dh.Writes()
+	for i := 0; i < 200; i++ {
+		lg.Err("not limited")
+	}
+	if n := dh.Writes() - written; n != 200 {
+		t.Errorf("Expected all err lines, got %d", n)
+	}
+
+	// the bucket refills over time
+	advance(time.Second)
+	written = dh.Writes()
+	for i := 0; i < 200; i++ {
+		lg.Info("after a second")
+	}
+	if n := dh.Writes() - written; n != 100 {
+		t.Errorf("Expected 100 lines after a second, got %d", n)
+	}
+
+	lg.SetRateLimit(InfoSeverity, 0)
+	written = dh.Writes()
+	for i := 0; i < 200; i++ {
+		lg.Info("unlimited")
+	}
+	if n := dh.Writes() - written; n != 200 {
+		t.Errorf("Expected no limit after removing it, got %d", n)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type sampleKey struct {
+	level SeverityFilter
+	msg   string
+}
+
+// sampler collapses identical messages logged within a window. The first message
+// is written, the repeats are counted and written as one summary line when the
+// window expires.
+type sampler struct {
+	l      *Logger4go
+	window time.Duration
+	counts map[sampleKey]int // repeats per message in the current window
+	order  []sampleKey       // messages in order of first occurrence
+	timer  *time.Timer
+	mutex  sync.Mutex
+}
+
+// SetSampler collapses identical messages of the same severity logged within window,
+// e.g. an error logged in a tight loop. The first message is written immediately and the
+// repeats are summarized as "<message> ...repeated N times" when the window expires or
+// the handlers are closed with CloseAll. Messages are compared after formatting, fields
+// are not compared and not repeated in the summary. A window of 0 disables sampling.
+func (l *Logger4go) SetSampler(window time.Duration) {
+	var s *sampler
+	if window > 0 {
+		s = &sampler{l: l, window: window, counts: make(map[sampleKey]int)}
+	}
+
+	l.mutex.Lock()
+	old := l.sampler
+	l.sampler = s
+	l.mutex.Unlock()
+
+	if old != nil {
+		old.flush()
+	}
+}
+
+// flushSampler writes the pending repeat summaries.
+func (l *Logger4go) flushSampler() {
+	l.mutex.Lock()
+	s := l.sampler
+	l.mutex.Unlock()
+
+	if s != nil {
+		s.flush()
+	}
+}
+
+// allow returns true if the message is the first of its kind in the window.
+func (s *sampler) allow(level SeverityFilter, msg string) bool {
+	k := sampleKey{level, msg}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.counts[k]; ok {
+		s.counts[k]++
+		return false
+	}
+	s.counts[k] = 0
+	s.order = append(s.order, k)
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.window, s.flush)
+	}
+	return true
+}
+
+// flush writes a summary for each repeated message and starts a new window.
+func (s *sampler) flush() {
+	s.mutex.Lock()
+	counts, order := s.counts, s.order
+	s.counts, s.order = make(map[sampleKey]int), nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mutex.Unlock()
+
+	flags := s.l.Flags()
+	for _, k := range order {
+		if n := counts[k]; n > 0 {
+			e := &Entry{Time: now(), Level: k.level, Name: s.l.name, Prefix: s.l.Prefix(), Flags: flags,
+				Message: fmt.Sprintf("%s ...repeated %d times", k.msg, n)}
+			if flags&(log.Lshortfile|log.Llongfile) != 0 {
+				// a summary has no call site
+				e.File = "???"
+			}
+			s.l.writeEntry(e)
+		}
+	}
+}
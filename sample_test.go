@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alyu/logger/handler"
+)
+
+func TestSampler(t *testing.T) {
+	lg := GetWithFlags("sampled", 0)
+	mh := handler.NewMemoryHandler()
+	lg.AddHandler(mh)
+	lg.SetSampler(time.Hour)
+	defer lg.SetSampler(0)
+
+	for i := 0; i < 100; i++ {
+		lg.Err("connection refused")
+	}
+	lg.Warning("connection refused")
+	lg.Err("disk full")
+	lg.Err("disk full")
+	lg.flushSampler()
+	lg.Err("connection refused")
+
+	want := []string{
+		"sampled  err      connection refused",
+		"sampled  warning  connection refused",
+		"sampled  err      disk full",
+		"sampled  err      connection refused ...repeated 99 times",
+		"sampled  err      disk full ...repeated 1 times",
+		"sampled  err      connection refused",
+	}
+	if got := mh.Lines(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Unexpected lines:\n got  %q\n want %q", got, want)
+	}
+}
+
+func TestSamplerWindowExpiry(t *testing.T) {
+	lg := GetWithFlags("sampled-window", 0)
+	mh := handler.NewMemoryHandler()
+	lg.AddHandler(mh)
+	lg.SetSampler(10 * time.Millisecond)
+	defer lg.SetSampler(0)
+
+	for i := 0; i < 100; i++ {
+		lg.Err("timeout")
+	}
+	for i := 0; i < 500 && len(mh.Lines()) < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	want := []string{"sampled-window  err      timeout", "sampled-window  err      timeout ...repeated 99 times"}
+	if got := mh.Lines(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Unexpected lines:\n got  %q\n want %q", got, want)
+	}
+}
+
+func TestSamplerSummaryCaller(t *testing.T) {
+	lg := GetWithFlags("sampled_json", 0)
+	lg.SetFormatter(&JSONFormatter{})
+	mh := handler.NewMemoryHandler()
+	lg.AddHandler(mh)
+	lg.SetSampler(time.Hour)
+	defer lg.SetSampler(0)
+
+	lg.Err("disk full")
+	lg.Err("disk full")
+	lg.flushSampler()
+
+	lines := mh.Lines()
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line and a summary, got %q", lines)
+	}
+	if strings.Contains(lines[1], "caller") {
+		t.Errorf("Unexpected caller in the summary without caller flags: %s", lines[1])
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+// maxSeverityBit is the highest bit a level can have, the logger filter is an int32.
+const maxSeverityBit = 30
+
+var (
+	registerMutex    sync.Mutex                        // serializes RegisterSeverity
+	customMutex      sync.RWMutex                      // guards the severity names, order and syslog severities
+	customSeverities = make(map[SeverityFilter]string) // names of the registered levels
+)
+
+// RegisterSeverity registers a custom level with the given name, e.g. "verbose", and returns
+// it. The level gets an unused bit and is less severe than the levels before it, so that
+// SetMinLevel(level) passes it and all other levels. It is not part of AllSeverity, add it
+// to the filters that should pass it. Its name is returned by String, parsed by ParseSeverity
+// and it is sent to syslog as LOG_DEBUG. A name longer than the others widens the level
+// column of the text formatter.
+//
+// Levels can be registered while logging. Registering a name again returns the level it
+// already has. RegisterSeverity panics when no bits are left.
+//
+//	var Verbose = logger.RegisterSeverity("verbose")
+//	lg.SetMinLevel(Verbose)
+//	lg.Logf(Verbose, "cache miss for %s", key)
+func RegisterSeverity(name string) SeverityFilter {
+	registerMutex.Lock()
+	defer registerMutex.Unlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	f, added := addSeverity(name)
+	if added {
+		// reads the names, not under customMutex
+		buildLevelColumns()
+	}
+	return f
+}
+
+// addSeverity adds a level named name unless it exists and returns it.
+func addSeverity(name string) (SeverityFilter, bool) {
+	customMutex.Lock()
+	defer customMutex.Unlock()
+
+	if f, ok := severityNames[name]; ok {
+		return f, false
+	}
+
+	var used SeverityFilter
+	for _, s := range severityOrder {
+		used |= s
+	}
+	for bit := 0; bit <= maxSeverityBit; bit++ {
+		f := SeverityFilter(1) << bit
+		if used&f != 0 || f&AllSeverity != 0 {
+			continue
+		}
+		customSeverities[f] = name
+		severityNames[name] = f
+		syslogSeverities[f] = syslog.LOG_DEBUG
+		// copy on write, severities returns the current slice
+		severityOrder = append(severityOrder[:len(severityOrder):len(severityOrder)], f)
+		return f, true
+	}
+	panic(fmt.Sprintf("logger: no severity bits left to register %q", name))
+}
+
+// severities returns severityOrder, the levels from the most to the least severe.
+func severities() []SeverityFilter {
+	customMutex.RLock()
+	defer customMutex.RUnlock()
+
+	return severityOrder
+}
+
+// customName returns the name of a registered level, "" if s is none.
+func customName(s SeverityFilter) string {
+	customMutex.RLock()
+	defer customMutex.RUnlock()
+
+	return customSeverities[s]
+}
+
+// syslogSeverity returns the syslog severity of the level f.
+func syslogSeverity(f SeverityFilter) (syslog.Priority, bool) {
+	customMutex.RLock()
+	defer customMutex.RUnlock()
+
+	severity, ok := syslogSeverities[f]
+	return severity, ok
+}
+
+// Logf logs with the given level, e.g. a level from RegisterSeverity.
+func (l *Logger4go) Logf(level SeverityFilter, format string, v ...interface{}) {
+	l.doPrintf(level, format, v...)
+}
+
+// Log logs with the given level, e.g. a level from RegisterSeverity.
+func (l *Logger4go) Log(level SeverityFilter, v ...interface{}) {
+	l.doPrintf(level, "%s", v...)
+}
@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alyu/logger/handler"
+)
+
+func TestRegisterSeverity(t *testing.T) {
+	trace := RegisterSeverity("wire")
+	if again := RegisterSeverity("Wire"); again != trace {
+		t.Errorf("Expected the same level when registering again, got %v", again)
+	}
+	if trace&AllSeverity != 0 {
+		t.Fatalf("Expected an unused bit, got %d", trace)
+	}
+	if f, err := ParseSeverity(trace.String()); err != nil || f != trace {
+		t.Errorf("Expected %q to round-trip, got %v, %v", trace, f, err)
+	}
+
+	l := GetWithFlags("custom", 0)
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+	l.SetMinLevel(trace)
+	l.Logf(trace, "sent %d bytes", 42)
+	l.SetMinLevel(TraceSeverity)
+	l.Log(trace, "filtered")
+	l.Trace("passes")
+
+	want := []string{
+		"custom  wire     sent 42 bytes",
+		"custom  trace    passes",
+	}
+	if lines := mh.Lines(); !reflect.DeepEqual(lines, want) {
+		t.Errorf("Unexpected lines:\n got  %q\n want %q", lines, want)
+	}
+}
+
+func TestRouteFormatCustomSeverity(t *testing.T) {
+	wire := RegisterSeverity("wire")
+	l := GetWithFlags("custom_route", 0)
+	mh := handler.NewMemoryHandler()
+	l.AddHandler(mh)
+	l.SetMinLevel(wire)
+	l.RouteFormat(wire, mh, &JSONFormatter{})
+
+	l.Logf(wire, "sent")
+	l.Info("served")
+
+	lines := mh.Lines()
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "{") || lines[1] != "custom_route  info     served" {
+		t.Errorf("Expected only the wire line as JSON, got %q", lines)
+	}
+}
+
+func TestRegisterSeverityConcurrent(t *testing.T) {
+	started, stop, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			ParseSeverity("dump")
+			SeverityAtLeast(InfoSeverity)
+			_ = SeverityFilter(1 << 20).String()
+			if i == 0 {
+				close(started)
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	<-started
+	dump := RegisterSeverity("dump")
+	close(stop)
+	<-done
+
+	if f, err := ParseSeverity("dump"); err != nil || f != dump {
+		t.Errorf("Expected the registered level, got %v, %v", f, err)
+	}
+}
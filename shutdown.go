@@ -0,0 +1,65 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	shutdownMutex  sync.Mutex
+	shutdownCancel func()
+)
+
+// HandleShutdownSignals closes all loggers with CloseAll when one of the signals is received
+// and then exits with status 128 + the signal number, as a shell would report it. Without
+// signals SIGINT and SIGTERM are handled. This makes sure that buffered lines are written out
+// when e.g. a container is stopped.
+//
+// Calling it again replaces the signals handled. The returned function stops handling the
+// signals and restores their default behavior, it can be called more than once.
+func HandleShutdownSignals(sigs ...os.Signal) (cancel func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	if shutdownCancel != nil {
+		shutdownCancel()
+	}
+
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sigs...)
+	go func() {
+		select {
+		case sig := <-c:
+			if err := CloseAll(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			exit(code)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	shutdownCancel = func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+	return shutdownCancel
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2013 - Alex Yu <alex@alexyu.se>. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+//go:build go1.21
+
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogHandler writes slog records to a Logger4go.
+type slogHandler struct {
+	l      *Logger4go
+	attrs  Fields // attributes added with WithAttrs, keys qualified by their groups
+	prefix string // qualifies attribute keys, "group." for each open group
+}
+
+// NewSlogHandler returns a slog.Handler writing the records to l, through its severity
+// filter, formatter and handlers, so that code using log/slog logs like the rest of the
+// application. The attributes become fields, qualified with the group names as
+// "group.key". The slog levels are mapped to the severities:
+//
+//	below slog.LevelDebug            trace
+//	slog.LevelDebug to LevelInfo     debug
+//	slog.LevelInfo to LevelWarn      info
+//	slog.LevelWarn to LevelError     warning
+//	slog.LevelError and above        err
+//
+//	log := slog.New(logger.NewSlogHandler(logger.Get("api")))
+//	log.Info("Request served", "status", 200)
+func NewSlogHandler(l *Logger4go) slog.Handler {
+	return &slogHandler{l: l}
+}
+
+// slogSeverity returns the severity of a slog level.
+func slogSeverity(level slog.Level) SeverityFilter {
+	switch {
+	case level < slog.LevelDebug:
+		return TraceSeverity
+	case level < slog.LevelInfo:
+		return DebugSeverity
+	case level < slog.LevelWarn:
+		return InfoSeverity
+	case level < slog.LevelError:
+		return WarningSeverity
+	default:
+		return ErrSeverity
+	}
+}
+
+// Enabled reports whether the logger writes lines of the level.
+func (sh *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return sh.l.Enabled(slogSeverity(level))
+}
+
+// Handle writes the record.
+func (sh *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	f := slogSeverity(r.Level)
+	if !sh.l.Enabled(f) || !sh.l.rateAllowed(f) {
+		return nil
+	}
+
+	fields := mergeFields(sh.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(fields, sh.prefix, a)
+		return true
+	})
+	t := r.Time
+	if t.IsZero() {
+		t = now()
+	}
+	sh.l.outputAt(t, f, fields, r.Message, r.PC)
+	return nil
+}
+
+// WithAttrs returns a handler adding attrs to each record.
+func (sh *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *sh
+	c.attrs = mergeFields(sh.attrs)
+	for _, a := range attrs {
+		addAttr(c.attrs, sh.prefix, a)
+	}
+	return &c
+}
+
+// WithGroup returns a handler qualifying the keys of the following attributes with name.
+func (sh *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return sh
+	}
+	c := *sh
+	c.prefix = sh.prefix + name + "."
+	return &c
+}
+
+// addAttr adds a to fields with its key qualified by prefix. Groups are flattened.
+func addAttr(fields Fields, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addAttr(fields, prefix, ga)
+		}
+		return
+	}
+	fields[prefix+a.Key] = a.Value.Any()
+}
@@ -0,0 +1,43 @@
+//go:build go1.21
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	l := GetWithFlags("slog", 0)
+	l.SetFormatter(&JSONFormatter{})
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+	l.SetMinLevel(InfoSeverity)
+
+	log := slog.New(NewSlogHandler(l)).With("service", "api")
+	log.Debug("filtered")
+	log.Warn("slow request", "ms", 1500)
+	log.WithGroup("req").Error("failed", "method", "GET", slog.Group("user", "id", 42))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	var warn, failed map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &warn); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &failed); err != nil {
+		t.Fatal(err)
+	}
+
+	if warn["level"] != "warning" || warn["msg"] != "slow request" || warn["ms"] != 1500.0 || warn["service"] != "api" {
+		t.Errorf("Unexpected warn line %s", lines[0])
+	}
+	if failed["level"] != "err" || failed["req.method"] != "GET" || failed["req.user.id"] != 42.0 || failed["service"] != "api" {
+		t.Errorf("Unexpected error line %s", lines[1])
+	}
+}